@@ -2,26 +2,159 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	"tlex/helper"
+
+	units "github.com/docker/go-units"
+)
+
+// Stats output formats.
+const (
+	// StatsFormatText writes a multi-line text block per stats snapshot.
+	StatsFormatText = "text"
+	// StatsFormatJSON writes a JSON object per stats snapshot per line.
+	StatsFormatJSON = "json"
+)
+
+// Container log output formats.
+const (
+	// LogFormatText writes a line per log record: timestamp, host port, container name, stream and message.
+	LogFormatText = "text"
+	// LogFormatJSON writes a JSON object per log record per line.
+	LogFormatJSON = "json"
+)
+
+// Container log sinks other than URLs.
+const (
+	// LogSinkStdout writes the container log lines to stdout.
+	LogSinkStdout = "stdout"
+	// LogSinkFile writes the container log lines to LogFilename.
+	LogSinkFile = "file"
 )
 
-// AppConfig holds the app configuration values
+// Launch failure policies.
+const (
+	// LaunchFailureAbort stops the launched containers once a container fails to launch.
+	LaunchFailureAbort = "abort"
+	// LaunchFailureContinue goes on with the launched containers once a container fails LaunchAttempts launches.
+	LaunchFailureContinue = "continue"
+	// LaunchFailureRetry retries every failure until every container is launched.
+	LaunchFailureRetry = "retry"
+)
+
+// Container restart policies of the daemon.
+const (
+	// RestartPolicyNo leaves the dead containers to self-healing, see MaxRestarts.
+	RestartPolicyNo = "no"
+	// RestartPolicyOnFailure restarts the containers exiting with a non zero code.
+	RestartPolicyOnFailure = "on-failure"
+	// RestartPolicyAlways restarts the containers whatever their exit code.
+	RestartPolicyAlways = "always"
+	// RestartPolicyUnlessStopped restarts the containers unless stopped.
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// LogSinkSchemes are the URL schemes of the network container log sinks.
+var LogSinkSchemes = []string{"syslog+udp", "syslog+tcp", "tcp", "http", "https"}
+
+// AppConfig holds the app configuration values.
+// The json tag is the key used in a config file, and derives the TLEX_* environment variable
+// and the command line flag name e.g. requestedLiveContainers -> TLEX_REQUESTED_LIVE_CONTAINERS, --requested-live-containers.
 type AppConfig struct {
-	DockerFilename               string
-	DockerImageName              string
-	DockerExposedPort            int
-	RequestedLiveContainers      int
-	StartingHTTPServerNattedPort int
-	ContainerRunningStateString  string
-	LogFilename                  string
-	StatsFilename                string
-	StatsPersist                 bool
-	StatsDisplay                 bool
+	DockerFilename               string `json:"dockerFilename" usage:"path of the Dockerfile to build"`
+	DockerImageName              string `json:"dockerImageName" usage:"docker image name to launch"`
+	DockerExposedPort            int    `json:"dockerExposedPort" usage:"container http server listening port"`
+	RequestedLiveContainers      int    `json:"requestedLiveContainers" usage:"number of containers to launch"`
+	StartingHTTPServerNattedPort int    `json:"startingHttpServerNattedPort" usage:"first host port mapped to the containers"`
+	ContainerRunningStateString  string `json:"containerRunningStateString" usage:"docker state of a live container"`
+	LogFilename                  string `json:"logFilename" usage:"aggregated containers log file"`
+	LogFormat                    string `json:"logFormat" usage:"containers log output format: text or json (one object per line)"`
+	StatsFilename                string `json:"statsFilename" usage:"aggregated containers stats file"`
+	EventsFilename               string `json:"eventsFilename" usage:"containers lifecycle events file, rotated per logRotation"`
+	StatsPersist                 bool   `json:"statsPersist" usage:"persist stats to the stats file"`
+	StatsDisplay                 bool   `json:"statsDisplay" usage:"display stats on stdout"`
+	StatsFormat                  string `json:"statsFormat" usage:"stats output format: text or json (one object per line)"`
+	// Rotation and retention of the log and stats files.
+	LogRotation   Rotation `json:"logRotation"`
+	StatsRotation Rotation `json:"statsRotation"`
+	// Container log destinations: stdout, file (LogFilename), syslog+udp://host:port, syslog+tcp://host:port,
+	// tcp://host:port for raw lines and http(s):// URLs receiving POSTed batches of LogHTTPBatchSize lines.
+	LogSinks         []string `json:"logSinks" usage:"comma separated container log destinations: stdout, file, syslog+udp://host:port, syslog+tcp://host:port, tcp://host:port, http(s)://url"`
+	LogHTTPBatchSize int      `json:"logHttpBatchSize" usage:"container log lines per HTTP POST"`
+	// Container log lines matching an exclude pattern, or none of the include patterns if any, are dropped.
+	// LogFilter applies to every container and ContainerLogFilters, keyed by host port, to single ones.
+	LogFilter           LogFilter            `json:"logFilter"`
+	ContainerLogFilters map[string]LogFilter `json:"containerLogFilters"`
+	// LogLevelDetection finds the ERROR, WARN, INFO or DEBUG level of a container log line in its text,
+	// info otherwise. The lines below LogStdoutLevel do not reach stdout and those below LogPersistLevel
+	// do not reach the other sinks.
+	LogLevelDetection bool   `json:"logLevelDetection" usage:"detect the level of the container log lines in their text"`
+	LogStdoutLevel    string `json:"logStdoutLevel" usage:"least container log level reaching stdout: debug, info, warn or error"`
+	LogPersistLevel   string `json:"logPersistLevel" usage:"least container log level reaching the file and network sinks: debug, info, warn or error"`
+	// Log and stats file writers queue up to LogBufferSize lines, dropping the rest, and flush them every LogFlushInterval.
+	LogBufferSize    int      `json:"logBufferSize" usage:"lines queued to each of the log and stats file writers"`
+	LogFlushInterval Duration `json:"logFlushInterval" usage:"longest time a line stays buffered before written to file"`
 	// display every modulus throttleStatsInputRequests
-	ThrottleStatsInputRequests int
+	ThrottleStatsInputRequests int `json:"throttleStatsInputRequests" usage:"display every nth stats snapshot"`
+	// Empty disables the Prometheus /metrics endpoint.
+	MetricsAddress string `json:"metricsAddress" usage:"host:port serving the Prometheus /metrics endpoint e.g. :9100"`
+	// A dropped log or stats stream of a running container is reopened after a backoff doubling from
+	// StreamReattachBackoff up to StreamReattachMaxBackoff.
+	StreamReattachBackoff    Duration `json:"streamReattachBackoff" usage:"first wait before reopening a dropped log or stats stream"`
+	StreamReattachMaxBackoff Duration `json:"streamReattachMaxBackoff" usage:"longest wait before reopening a dropped log or stats stream"`
+	// A dead owned container is relaunched on its host port after a backoff doubling from RestartBackoff
+	// up to RestartMaxBackoff, at most MaxRestarts times per host port. 0 disables self-healing.
+	// The owned containers are checked every ReconcileInterval.
+	MaxRestarts       int      `json:"maxRestarts" usage:"relaunch attempts of the dead containers per host port, 0 disables self-healing"`
+	RestartBackoff    Duration `json:"restartBackoff" usage:"first wait before relaunching a dead container"`
+	RestartMaxBackoff Duration `json:"restartMaxBackoff" usage:"longest wait before relaunching a dead container"`
+	ReconcileInterval Duration `json:"reconcileInterval" usage:"interval between checks of the owned containers being live"`
+	// The echo endpoint of every container is probed every HealthCheckInterval by a GET of HealthCheckPath at
+	// HealthCheckHost:hostPort expecting the path echoed back within HealthCheckTimeout. A container turns healthy
+	// after HealthCheckSuccessThreshold passing probes in a row and unhealthy after HealthCheckFailureThreshold
+	// failing ones. The launch waits up to HealthCheckStartTimeout for all to turn healthy. Empty HealthCheckPath
+	// disables the probes.
+	HealthCheckPath             string   `json:"healthCheckPath" usage:"path echoed back by a healthy container, empty disables the health checks"`
+	HealthCheckHost             string   `json:"healthCheckHost" usage:"host publishing the containers' ports"`
+	HealthCheckInterval         Duration `json:"healthCheckInterval" usage:"interval between the health probes of a container"`
+	HealthCheckTimeout          Duration `json:"healthCheckTimeout" usage:"timeout of a health probe"`
+	HealthCheckSuccessThreshold int      `json:"healthCheckSuccessThreshold" usage:"passing probes in a row turning a container healthy"`
+	HealthCheckFailureThreshold int      `json:"healthCheckFailureThreshold" usage:"failing probes in a row turning a container unhealthy"`
+	HealthCheckStartTimeout     Duration `json:"healthCheckStartTimeout" usage:"longest wait for the launched containers to turn healthy, 0 waits indefinitely"`
+	// The host ports of the containers are the first ones from StartingHTTPServerNattedPort up to
+	// LastHTTPServerNattedPort free on the host and not in DeniedHostPorts e.g. 8775,9000-9010.
+	// EphemeralHostPorts lets Docker choose them instead.
+	LastHTTPServerNattedPort int      `json:"lastHttpServerNattedPort" usage:"last host port mapped to the containers, 0 for 65535"`
+	DeniedHostPorts          []string `json:"deniedHostPorts" usage:"comma separated host ports or port ranges never mapped to the containers e.g. 8775,9000-9010"`
+	EphemeralHostPorts       bool     `json:"ephemeralHostPorts" usage:"let Docker choose the host ports mapped to the containers"`
+	// RemoveStaleContainers removes the HttpServerAt_* containers of the launches recorded in the state file
+	// left created or exited, which would hold their names, before launching.
+	RemoveStaleContainers bool `json:"removeStaleContainers" usage:"remove the containers of the recorded earlier launches left created or exited before launching"`
+	// At most LaunchConcurrency containers are created and started at once and LaunchRate a second, 0 lifts
+	// either limit. The launch progress is logged every LaunchProgressInterval, 0 disables it.
+	LaunchConcurrency      int      `json:"launchConcurrency" usage:"most containers created and started at once, 0 for all"`
+	LaunchRate             float64  `json:"launchRate" usage:"most container launches a second, 0 for unlimited"`
+	LaunchProgressInterval Duration `json:"launchProgressInterval" usage:"interval between launch progress lines, 0 disables them"`
+	// A container launch failing transiently e.g. timed out, on a busy daemon or a stale container's name, is retried
+	// after LaunchRetryBackoff, doubling up to LaunchRetryMaxBackoff and jittered, up to LaunchAttempts launches.
+	// The containers failing anyway abort the launch. With LaunchFailurePolicy continue any failure is retried
+	// up to LaunchAttempts launches, then the container is left out. With retry any failure is retried until launched.
+	LaunchAttempts        int      `json:"launchAttempts" usage:"launches of a failing container, of one failing transiently only with the abort policy"`
+	LaunchRetryBackoff    Duration `json:"launchRetryBackoff" usage:"first wait before launching a container again"`
+	LaunchRetryMaxBackoff Duration `json:"launchRetryMaxBackoff" usage:"longest wait before launching a container again"`
+	LaunchFailurePolicy   string   `json:"launchFailurePolicy" usage:"on containers failing to launch: abort, continue with the launched ones or retry until all are launched"`
+	// ContainerResources limits every container and sets its daemon restart policy.
+	ContainerResources Resources `json:"containerResources"`
+	// Per Docker operation timeouts. 0 waits indefinitely or until interrupted.
+	LaunchTimeout Duration `json:"launchTimeout" usage:"timeout creating and starting each container"`
+	StopTimeout   Duration `json:"stopTimeout" usage:"timeout stopping each container"`
+	BuildTimeout  Duration `json:"buildTimeout" usage:"timeout building the image"`
 	// Used for unit testing to wait on channels to sync up with unit tests
-	InTestingModeWithChannelsSync bool
+	InTestingModeWithChannelsSync bool `json:"-"`
 }
 
 // GetConfig returns the application parameters.
@@ -36,10 +169,45 @@ func GetConfig() AppConfig {
 		StartingHTTPServerNattedPort: 8770,
 		ContainerRunningStateString:  "running",
 		LogFilename:                  helper.GetCWD() + string(os.PathSeparator) + "containers.log",
+		LogFormat:                    LogFormatText,
 		StatsFilename:                helper.GetCWD() + string(os.PathSeparator) + "containers_stats.log",
+		EventsFilename:               helper.GetCWD() + string(os.PathSeparator) + "containers_events.log",
 		StatsPersist:                 true,
 		StatsDisplay:                 true,
+		StatsFormat:                  StatsFormatText,
+		LogRotation:                  Rotation{MaxSizeMB: 100, MaxFiles: 5},
+		StatsRotation:                Rotation{MaxSizeMB: 100, MaxFiles: 5},
+		LogSinks:                     []string{LogSinkStdout, LogSinkFile},
+		LogHTTPBatchSize:             100,
+		LogStdoutLevel:               "info",
+		LogPersistLevel:              "debug",
+		LogBufferSize:                4096,
+		LogFlushInterval:             Duration(time.Second),
 		ThrottleStatsInputRequests:   20,
+		StreamReattachBackoff:        Duration(500 * time.Millisecond),
+		StreamReattachMaxBackoff:     Duration(30 * time.Second),
+		MaxRestarts:                  3,
+		RestartBackoff:               Duration(time.Second),
+		RestartMaxBackoff:            Duration(time.Minute),
+		ReconcileInterval:            Duration(5 * time.Second),
+		HealthCheckPath:              "/tlex/health/",
+		HealthCheckHost:              "localhost",
+		HealthCheckInterval:          Duration(time.Second),
+		HealthCheckTimeout:           Duration(2 * time.Second),
+		HealthCheckSuccessThreshold:  1,
+		HealthCheckFailureThreshold:  3,
+		HealthCheckStartTimeout:      Duration(time.Minute),
+		RemoveStaleContainers:        true,
+		LaunchConcurrency:            10,
+		LaunchProgressInterval:       Duration(time.Second),
+		LaunchAttempts:               3,
+		LaunchRetryBackoff:           Duration(500 * time.Millisecond),
+		LaunchRetryMaxBackoff:        Duration(10 * time.Second),
+		LaunchFailurePolicy:          LaunchFailureAbort,
+		ContainerResources:           Resources{RestartPolicy: RestartPolicyNo},
+		LaunchTimeout:                Duration(30 * time.Second),
+		StopTimeout:                  Duration(20 * time.Second),
+		BuildTimeout:                 Duration(5 * time.Minute),
 
 		//*** Note if InTestingModeWithChannelsSync is set to true during
 		// normal operation it will wait on the containersChecked channel after erasing the containers.
@@ -50,3 +218,129 @@ func GetConfig() AppConfig {
 
 	return config
 }
+
+// Rotation configures the rotation and retention of a log file.
+// The file is rotated when either limit is reached; 0 disables a limit.
+type Rotation struct {
+	MaxSizeMB int      `json:"maxSizeMb" usage:"rotate the file past this size in MiB, 0 disables"`
+	MaxAge    Duration `json:"maxAge" usage:"rotate the file after this duration e.g. 24h, 0 disables"`
+	MaxFiles  int      `json:"maxFiles" usage:"number of rotated files to retain, 0 retains all"`
+	Compress  bool     `json:"compress" usage:"gzip the rotated files"`
+}
+
+// Resources configures the resource limits of a container; 0 leaves a limit to the daemon default.
+// A container restarted by the daemon, RestartPolicy other than no, is not auto removed.
+type Resources struct {
+	CPUs       float64  `json:"cpus" usage:"CPUs per container e.g. 1.5, 0 for unlimited"`
+	Memory     ByteSize `json:"memory" usage:"memory limit per container e.g. 512m, 0 for unlimited"`
+	MemorySwap ByteSize `json:"memorySwap" usage:"memory plus swap limit per container e.g. 1g, -1 for unlimited swap, 0 for twice the memory"`
+	PidsLimit  int64    `json:"pidsLimit" usage:"most processes per container, 0 for unlimited"`
+	Ulimits    []string `json:"ulimits" usage:"comma separated ulimits per container e.g. nofile=1024:2048"`
+	// The daemon restarts the dead containers per RestartPolicy, at most RestartMaxRetries times with on-failure.
+	RestartPolicy     string `json:"restartPolicy" usage:"daemon restart policy of the containers: no, on-failure, always or unless-stopped"`
+	RestartMaxRetries int    `json:"restartMaxRetries" usage:"restarts of a container by the on-failure policy, 0 for unlimited"`
+}
+
+// LogFilter selects container log lines by regular expressions matched against their message.
+type LogFilter struct {
+	Include []string `json:"include" usage:"comma separated regular expressions, a line must match one of"`
+	Exclude []string `json:"exclude" usage:"comma separated regular expressions, a line must match none of"`
+}
+
+// Duration is a time.Duration read and written as a string e.g. "30s" in config files, env and flags.
+type Duration time.Duration
+
+func (d Duration) String() string {
+
+	return time.Duration(d).String()
+}
+
+// MarshalText renders the duration e.g. 1m30s.
+func (d Duration) MarshalText() ([]byte, error) {
+
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText parses a time.ParseDuration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// ByteSize is a byte count read as a human size e.g. "512m" or "1.5GiB" in config files, env and flags.
+// -1 stands for unlimited.
+type ByteSize int64
+
+func (size ByteSize) String() string {
+
+	if size <= 0 {
+		return strconv.FormatInt(int64(size), 10)
+	}
+
+	return units.BytesSize(float64(size))
+}
+
+// MarshalText renders the size e.g. 512MiB.
+func (size ByteSize) MarshalText() ([]byte, error) {
+
+	return []byte(size.String()), nil
+}
+
+// UnmarshalText parses a size in bytes, a binary multiple e.g. 512m, or -1.
+func (size *ByteSize) UnmarshalText(text []byte) error {
+
+	if string(text) == "-1" {
+		*size = -1
+		return nil
+	}
+	parsed, err := units.RAMInBytes(string(text))
+	if err != nil {
+		return err
+	}
+	*size = ByteSize(parsed)
+
+	return nil
+}
+
+// ParseUlimits returns the ulimits of specs, each a name=soft[:hard] ulimit e.g. nofile=1024:2048.
+func ParseUlimits(specs []string) ([]*units.Ulimit, error) {
+
+	ulimits := make([]*units.Ulimit, 0, len(specs))
+	for _, spec := range specs {
+		ulimit, err := units.ParseUlimit(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		ulimits = append(ulimits, ulimit)
+	}
+
+	return ulimits, nil
+}
+
+// ParsePortRanges returns the ports of specs, each a port e.g. 8775 or an inclusive port range e.g. 9000-9010.
+func ParsePortRanges(specs []string) (map[int]bool, error) {
+
+	ports := make(map[int]bool)
+	for _, spec := range specs {
+		bounds := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		last := first
+		if err == nil && len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || first < 1 || last > maxPort || first > last {
+			return nil, fmt.Errorf("%q is not a port or a port range within 1-65535", spec)
+		}
+		for port := first; port <= last; port++ {
+			ports[port] = true
+		}
+	}
+
+	return ports, nil
+}
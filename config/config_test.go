@@ -0,0 +1,181 @@
+// Package config holds defaults + desired application parameters for its operation.
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func noEnv(string) (string, bool) {
+
+	return "", false
+}
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+
+	dir, err := ioutil.TempDir("", "tlexconfig")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	filename := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	return filename
+}
+
+func Test_Defaults_Are_Valid(t *testing.T) {
+
+	cfg, err := load("tlex", nil, noEnv)
+	if err != nil {
+		t.Fatalf("load() of defaults error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg, GetConfig()) {
+		t.Errorf("load() without layers = %+v, want defaults %+v", cfg, GetConfig())
+	}
+}
+
+func Test_Config_File_Formats(t *testing.T) {
+
+	files := map[string]string{
+		"tlex.json": `{"requestedLiveContainers": 7, "dockerImageName": "echo:json"}`,
+		"tlex.yaml": "requestedLiveContainers: 7\ndockerImageName: echo:json\n",
+		"tlex.toml": "requestedLiveContainers = 7\ndockerImageName = \"echo:json\"\n",
+	}
+	for name, content := range files {
+		filename := writeConfigFile(t, name, content)
+		defer os.RemoveAll(filepath.Dir(filename))
+
+		cfg, err := load("tlex", []string{"--config", filename}, noEnv)
+		if err != nil {
+			t.Errorf("%s: load() error = %v", name, err)
+			continue
+		}
+		if cfg.RequestedLiveContainers != 7 || cfg.DockerImageName != "echo:json" {
+			t.Errorf("%s: got RequestedLiveContainers=%d DockerImageName=%s", name, cfg.RequestedLiveContainers, cfg.DockerImageName)
+		}
+		if cfg.StartingHTTPServerNattedPort != GetConfig().StartingHTTPServerNattedPort {
+			t.Errorf("%s: unset field StartingHTTPServerNattedPort lost its default", name)
+		}
+	}
+}
+
+func Test_Layer_Precedence(t *testing.T) {
+
+	filename := writeConfigFile(t, "tlex.json", `{"requestedLiveContainers": 3, "startingHttpServerNattedPort": 9000, "statsDisplay": true}`)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	env := map[string]string{
+		"TLEX_STARTING_HTTP_SERVER_NATTED_PORT": "9100",
+		"TLEX_REQUESTED_LIVE_CONTAINERS":        "4",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg, err := load("tlex", []string{"--config", filename, "--requested-live-containers", "5", "--stats-display=false"}, lookupEnv)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if cfg.StartingHTTPServerNattedPort != 9100 {
+		t.Errorf("env did not override file: StartingHTTPServerNattedPort = %d", cfg.StartingHTTPServerNattedPort)
+	}
+	if cfg.RequestedLiveContainers != 5 {
+		t.Errorf("flag did not override env: RequestedLiveContainers = %d", cfg.RequestedLiveContainers)
+	}
+	if cfg.StatsDisplay {
+		t.Errorf("flag did not override file: StatsDisplay = %v", cfg.StatsDisplay)
+	}
+}
+
+func Test_Errors_Name_The_Field(t *testing.T) {
+
+	tests := []struct {
+		args  []string
+		env   string
+		field string
+	}{
+		{args: []string{"--requested-live-containers", "-1"}, field: "RequestedLiveContainers"},
+		{args: []string{"--throttle-stats-input-requests", "0"}, field: "ThrottleStatsInputRequests"},
+		{args: []string{"--docker-exposed-port", "port"}, field: "DockerExposedPort"},
+		{args: []string{"--stats-rotation-max-files", "-1"}, field: "StatsRotation.MaxFiles"},
+		{args: []string{"--log-sinks", "stdout,ftp://collector"}, field: "LogSinks"},
+		{args: []string{"--log-filter-exclude", "GET /(health"}, field: "LogFilter"},
+		{args: []string{"--log-stdout-level", "loud"}, field: "LogStdoutLevel"},
+		{args: []string{"--restart-max-backoff", "1ms"}, field: "RestartMaxBackoff"},
+		{args: []string{"--health-check-failure-threshold", "0"}, field: "HealthCheckFailureThreshold"},
+		{args: []string{"--denied-host-ports", "8775,9010-9000"}, field: "DeniedHostPorts"},
+		{args: []string{"--launch-failure-policy", "ignore"}, field: "LaunchFailurePolicy"},
+		{args: []string{"--container-resources-memory", "256m", "--container-resources-memory-swap", "128m"}, field: "ContainerResources.MemorySwap"},
+		{args: []string{"--container-resources-ulimits", "nofile=2048:1024"}, field: "ContainerResources.Ulimits"},
+		{args: []string{"--container-resources-restart-policy", "always"}, field: "ContainerResources.RestartPolicy"},
+		{env: "70000", field: "StartingHTTPServerNattedPort"},
+	}
+	for _, tt := range tests {
+		lookupEnv := func(name string) (string, bool) {
+			return tt.env, tt.env != "" && name == "TLEX_STARTING_HTTP_SERVER_NATTED_PORT"
+		}
+		_, err := load("tlex", tt.args, lookupEnv)
+		if err == nil || !strings.Contains(err.Error(), tt.field) {
+			t.Errorf("load(%v) error = %v, want an error naming %s", tt.args, err, tt.field)
+		}
+	}
+}
+
+func Test_Container_Resources_Read_Human_Sizes(t *testing.T) {
+
+	filename := writeConfigFile(t, "tlex.yaml", "maxRestarts: 0\ncontainerResources:\n  cpus: 1.5\n  memory: 512m\n  restartPolicy: on-failure\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	cfg, err := load("tlex", []string{"--config", filename, "--container-resources-memory-swap", "-1", "--container-resources-ulimits", "nofile=1024:2048,nproc=64"}, noEnv)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	resources := cfg.ContainerResources
+	if resources.CPUs != 1.5 || resources.Memory != 512*1024*1024 || resources.MemorySwap != -1 || resources.RestartPolicy != RestartPolicyOnFailure {
+		t.Errorf("ContainerResources = %+v, want 1.5 CPUs, 512MiB of memory, unlimited swap and the on-failure policy", resources)
+	}
+	ulimits, err := ParseUlimits(resources.Ulimits)
+	if err != nil || len(ulimits) != 2 || ulimits[0].Name != "nofile" || ulimits[0].Soft != 1024 || ulimits[0].Hard != 2048 {
+		t.Errorf("ParseUlimits(%v) = %v, error = %v", resources.Ulimits, ulimits, err)
+	}
+}
+
+func Test_Unknown_File_Key_Fails(t *testing.T) {
+
+	filename := writeConfigFile(t, "tlex.yml", "requestedLiveContainer: 3\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	if _, err := load("tlex", []string{"--config", filename}, noEnv); err == nil {
+		t.Errorf("load() of a misspelled key did not produce an error")
+	}
+}
+
+func Test_Rotation_Is_Set_Per_Sink(t *testing.T) {
+
+	filename := writeConfigFile(t, "tlex.yaml", "logRotation:\n  maxFiles: 2\n  compress: true\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	lookupEnv := func(name string) (string, bool) {
+		return "1h", name == "TLEX_STATS_ROTATION_MAX_AGE"
+	}
+	cfg, err := load("tlex", []string{"--config", filename, "--log-rotation-max-size-mb", "10"}, lookupEnv)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	if want := (Rotation{MaxSizeMB: 10, MaxFiles: 2, Compress: true}); cfg.LogRotation != want {
+		t.Errorf("LogRotation = %+v, want %+v", cfg.LogRotation, want)
+	}
+	if want := (Rotation{MaxSizeMB: 100, MaxAge: Duration(time.Hour), MaxFiles: 5}); cfg.StatsRotation != want {
+		t.Errorf("StatsRotation = %+v, want %+v", cfg.StatsRotation, want)
+	}
+}
@@ -0,0 +1,322 @@
+// Package config holds defaults + desired application parameters for its operation.
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix prefixes every environment variable overriding an AppConfig field.
+const EnvPrefix = "TLEX_"
+
+// ConfigFlag is the command line flag naming the optional config file.
+const ConfigFlag = "config"
+
+// Load returns the layered application configuration, each layer overriding the previous one:
+// 1. GetConfig() defaults,
+// 2. the file named by --config (.json, .yaml, .yml or .toml),
+// 3. TLEX_* environment variables,
+// 4. command line flags in args.
+// name names the flag set in usage messages e.g. "tlex up".
+// The resulting configuration is validated before returning.
+func Load(name string, args []string) (AppConfig, error) {
+
+	return load(name, args, os.LookupEnv)
+}
+
+// load is Load with an injectable flag set name and environment lookup.
+func load(name string, args []string, lookupEnv func(string) (string, bool)) (AppConfig, error) {
+
+	cfg := GetConfig()
+	cfgFields := fieldsOf(&cfg)
+
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFilename := flagSet.String(ConfigFlag, "", "config file (.json, .yaml, .yml or .toml)")
+	flagValues := make(map[string]*flagValue, len(cfgFields))
+	for _, f := range cfgFields {
+		fv := &flagValue{field: f, raw: f.String()}
+		flagValues[f.flagName()] = fv
+		flagSet.Var(fv, f.flagName(), f.usage)
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFilename != "" {
+		if err := loadFile(&cfg, *configFilename); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, f := range cfgFields {
+		if raw, ok := lookupEnv(f.envName()); ok {
+			if err := f.set(raw); err != nil {
+				return cfg, &FieldError{Field: f.name, Value: raw, Reason: fmt.Sprintf("from %s: %v", f.envName(), err)}
+			}
+		}
+	}
+
+	var err error
+	flagSet.Visit(func(fl *flag.Flag) {
+		fv, ok := flagValues[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fv.field.set(fv.raw); setErr != nil {
+			err = &FieldError{Field: fv.field.name, Value: fv.raw, Reason: fmt.Sprintf("from --%s: %v", fl.Name, setErr)}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// loadFile decodes the config file onto cfg. YAML and TOML documents are normalized
+// through JSON so that a single set of json keys applies to every format.
+func loadFile(cfg *AppConfig, filename string) error {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("reading config file %s: %v", filename, err)
+	}
+
+	jsonData := data
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+	case ".yaml", ".yml":
+		var doc interface{}
+		if err = yaml.Unmarshal(data, &doc); err == nil {
+			jsonData, err = json.Marshal(normalizeYAML(doc))
+		}
+	case ".toml":
+		doc := make(map[string]interface{})
+		if _, err = toml.Decode(string(data), &doc); err == nil {
+			jsonData, err = json.Marshal(doc)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension, want .json, .yaml, .yml or .toml", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", filename, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %v", filename, err)
+	}
+
+	return nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} yaml.v2 produces into json encodable maps.
+func normalizeYAML(doc interface{}) interface{} {
+
+	switch node := doc.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for k, v := range node {
+			m[fmt.Sprintf("%v", k)] = normalizeYAML(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range node {
+			node[i] = normalizeYAML(v)
+		}
+	}
+
+	return doc
+}
+
+// configField is a settable scalar AppConfig field reachable from the environment and flags.
+type configField struct {
+	// name is the Go field path e.g. RequestedLiveContainers.
+	name string
+	// keys is the json key path e.g. [requestedLiveContainers].
+	keys  []string
+	usage string
+	value reflect.Value
+}
+
+// fieldsOf walks cfg, descending into nested structs, and returns its scalar fields.
+// Fields tagged json:"-" and non scalar fields (maps, struct slices) are file only.
+func fieldsOf(cfg *AppConfig) []configField {
+
+	return walkFields(reflect.ValueOf(cfg).Elem(), "", nil)
+}
+
+func walkFields(v reflect.Value, namePrefix string, keyPrefix []string) []configField {
+
+	var fields []configField
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		key := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" || structField.PkgPath != "" {
+			continue
+		}
+
+		keys := append(append([]string{}, keyPrefix...), key)
+		f := configField{name: namePrefix + structField.Name, keys: keys, usage: structField.Tag.Get("usage"), value: v.Field(i)}
+		switch {
+		case f.isScalar():
+			fields = append(fields, f)
+		case f.value.Kind() == reflect.Struct:
+			fields = append(fields, walkFields(f.value, f.name+".", keys)...)
+		}
+	}
+
+	return fields
+}
+
+func (f configField) isScalar() bool {
+
+	if _, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return true
+	}
+
+	switch f.value.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return f.value.Type().Elem().Kind() == reflect.String
+	}
+
+	return false
+}
+
+// words splits the json key path into lower case words e.g. [logRotation maxSizeMb] -> [log rotation max size mb].
+func (f configField) words() []string {
+
+	var words []string
+	for _, key := range f.keys {
+		runes := []rune(key)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			if unicode.IsUpper(runes[i]) && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				words = append(words, strings.ToLower(string(runes[start:i])))
+				start = i
+			}
+		}
+		words = append(words, strings.ToLower(string(runes[start:])))
+	}
+
+	return words
+}
+
+func (f configField) envName() string {
+
+	return EnvPrefix + strings.ToUpper(strings.Join(f.words(), "_"))
+}
+
+func (f configField) flagName() string {
+
+	return strings.Join(f.words(), "-")
+}
+
+// String renders the current field value in the same syntax set accepts.
+func (f configField) String() string {
+
+	if marshaler, ok := f.value.Interface().(encoding.TextMarshaler); ok {
+		text, _ := marshaler.MarshalText()
+		return string(text)
+	}
+	if f.value.Kind() == reflect.Slice {
+		return strings.Join(f.value.Interface().([]string), ",")
+	}
+
+	return fmt.Sprintf("%v", f.value.Interface())
+}
+
+// set parses raw into the field. String slices are comma separated.
+func (f configField) set(raw string) error {
+
+	if unmarshaler, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	}
+
+	return nil
+}
+
+// flagValue records a raw command line value until the file and environment layers are applied.
+type flagValue struct {
+	field configField
+	raw   string
+}
+
+func (fv *flagValue) String() string {
+
+	if fv == nil {
+		return ""
+	}
+
+	return fv.raw
+}
+
+func (fv *flagValue) Set(raw string) error {
+
+	fv.raw = raw
+
+	return nil
+}
+
+// IsBoolFlag allows --stats-display without an explicit =true.
+func (fv *flagValue) IsBoolFlag() bool {
+
+	return fv.field.value.Kind() == reflect.Bool
+}
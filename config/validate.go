@@ -0,0 +1,176 @@
+// Package config holds defaults + desired application parameters for its operation.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"tlex/logger"
+)
+
+const maxPort = 65535
+
+// FieldError reports an invalid AppConfig field value.
+type FieldError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+
+	return fmt.Sprintf("config: invalid %s=%v: %s", e.Field, e.Value, e.Reason)
+}
+
+// FieldErrors collects every invalid field found by Validate.
+type FieldErrors []*FieldError
+
+func (errs FieldErrors) Error() string {
+
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the configuration values and returns FieldErrors naming each invalid field or nil.
+func (cfg AppConfig) Validate() error {
+
+	var errs FieldErrors
+	check := func(valid bool, field string, value interface{}, reason string) {
+		if !valid {
+			errs = append(errs, &FieldError{Field: field, Value: value, Reason: reason})
+		}
+	}
+
+	check(cfg.DockerFilename != "", "DockerFilename", cfg.DockerFilename, "must not be empty")
+	check(cfg.DockerImageName != "", "DockerImageName", cfg.DockerImageName, "must not be empty")
+	check(cfg.DockerExposedPort > 0 && cfg.DockerExposedPort <= maxPort, "DockerExposedPort", cfg.DockerExposedPort, "must be a port within 1-65535")
+	check(cfg.RequestedLiveContainers >= 0, "RequestedLiveContainers", cfg.RequestedLiveContainers, "must not be negative")
+	check(cfg.StartingHTTPServerNattedPort > 0 && cfg.StartingHTTPServerNattedPort <= maxPort, "StartingHTTPServerNattedPort", cfg.StartingHTTPServerNattedPort, "must be a port within 1-65535")
+	check(cfg.StartingHTTPServerNattedPort+cfg.RequestedLiveContainers-1 <= maxPort, "StartingHTTPServerNattedPort", cfg.StartingHTTPServerNattedPort,
+		fmt.Sprintf("leaves no room for %d containers below port 65535", cfg.RequestedLiveContainers))
+	check(cfg.ContainerRunningStateString != "", "ContainerRunningStateString", cfg.ContainerRunningStateString, "must not be empty")
+	check(cfg.LogFilename != "", "LogFilename", cfg.LogFilename, "must not be empty")
+	check(cfg.LogFormat == LogFormatText || cfg.LogFormat == LogFormatJSON, "LogFormat", cfg.LogFormat, "must be text or json")
+	check(cfg.StatsFilename != "", "StatsFilename", cfg.StatsFilename, "must not be empty")
+	check(cfg.EventsFilename != "", "EventsFilename", cfg.EventsFilename, "must not be empty")
+	check(cfg.StatsFormat == StatsFormatText || cfg.StatsFormat == StatsFormatJSON, "StatsFormat", cfg.StatsFormat, "must be text or json")
+	checkRotation := func(field string, rotation Rotation) {
+		check(rotation.MaxSizeMB >= 0, field+".MaxSizeMB", rotation.MaxSizeMB, "must not be negative")
+		check(rotation.MaxAge >= 0, field+".MaxAge", rotation.MaxAge, "must not be negative")
+		check(rotation.MaxFiles >= 0, field+".MaxFiles", rotation.MaxFiles, "must not be negative")
+	}
+	checkRotation("LogRotation", cfg.LogRotation)
+	checkRotation("StatsRotation", cfg.StatsRotation)
+	for _, sink := range cfg.LogSinks {
+		check(validLogSink(sink), "LogSinks", sink, "must be stdout, file or a syslog+udp, syslog+tcp, tcp, http or https URL with a host")
+	}
+	check(cfg.LogHTTPBatchSize > 0, "LogHTTPBatchSize", cfg.LogHTTPBatchSize, "must be at least 1")
+	checkLogFilter := func(field string, filter LogFilter) {
+		for _, pattern := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+			_, err := regexp.Compile(pattern)
+			check(err == nil, field, pattern, fmt.Sprintf("must be a regular expression: %v", err))
+		}
+	}
+	checkLogFilter("LogFilter", cfg.LogFilter)
+	hostPorts := make([]string, 0, len(cfg.ContainerLogFilters))
+	for hostPort := range cfg.ContainerLogFilters {
+		hostPorts = append(hostPorts, hostPort)
+	}
+	sort.Strings(hostPorts)
+	for _, hostPort := range hostPorts {
+		port, err := strconv.Atoi(hostPort)
+		check(err == nil && port > 0 && port <= maxPort, "ContainerLogFilters", hostPort, "must be keyed by host port")
+		checkLogFilter("ContainerLogFilters["+hostPort+"]", cfg.ContainerLogFilters[hostPort])
+	}
+	_, err := logger.ParseLevel(cfg.LogStdoutLevel)
+	check(err == nil, "LogStdoutLevel", cfg.LogStdoutLevel, "must be debug, info, warn or error")
+	_, err = logger.ParseLevel(cfg.LogPersistLevel)
+	check(err == nil, "LogPersistLevel", cfg.LogPersistLevel, "must be debug, info, warn or error")
+	check(cfg.LogBufferSize > 0, "LogBufferSize", cfg.LogBufferSize, "must be at least 1")
+	check(cfg.LogFlushInterval > 0, "LogFlushInterval", cfg.LogFlushInterval, "must be positive")
+	check(cfg.ThrottleStatsInputRequests > 0, "ThrottleStatsInputRequests", cfg.ThrottleStatsInputRequests, "must be at least 1")
+	if cfg.MetricsAddress != "" {
+		_, _, err := net.SplitHostPort(cfg.MetricsAddress)
+		check(err == nil, "MetricsAddress", cfg.MetricsAddress, "must be a host:port address")
+	}
+	check(cfg.StreamReattachBackoff > 0, "StreamReattachBackoff", cfg.StreamReattachBackoff, "must be positive")
+	check(cfg.StreamReattachMaxBackoff >= cfg.StreamReattachBackoff, "StreamReattachMaxBackoff", cfg.StreamReattachMaxBackoff, "must not be less than StreamReattachBackoff")
+	check(cfg.MaxRestarts >= 0, "MaxRestarts", cfg.MaxRestarts, "must not be negative")
+	check(cfg.RestartBackoff > 0, "RestartBackoff", cfg.RestartBackoff, "must be positive")
+	check(cfg.RestartMaxBackoff >= cfg.RestartBackoff, "RestartMaxBackoff", cfg.RestartMaxBackoff, "must not be less than RestartBackoff")
+	check(cfg.ReconcileInterval > 0, "ReconcileInterval", cfg.ReconcileInterval, "must be positive")
+	if cfg.HealthCheckPath != "" {
+		check(cfg.HealthCheckHost != "", "HealthCheckHost", cfg.HealthCheckHost, "must not be empty")
+		check(cfg.HealthCheckInterval > 0, "HealthCheckInterval", cfg.HealthCheckInterval, "must be positive")
+		check(cfg.HealthCheckTimeout > 0, "HealthCheckTimeout", cfg.HealthCheckTimeout, "must be positive")
+		check(cfg.HealthCheckSuccessThreshold > 0, "HealthCheckSuccessThreshold", cfg.HealthCheckSuccessThreshold, "must be at least 1")
+		check(cfg.HealthCheckFailureThreshold > 0, "HealthCheckFailureThreshold", cfg.HealthCheckFailureThreshold, "must be at least 1")
+		check(cfg.HealthCheckStartTimeout >= 0, "HealthCheckStartTimeout", cfg.HealthCheckStartTimeout, "must not be negative")
+	}
+	check(cfg.LastHTTPServerNattedPort == 0 || cfg.LastHTTPServerNattedPort >= cfg.StartingHTTPServerNattedPort && cfg.LastHTTPServerNattedPort <= maxPort,
+		"LastHTTPServerNattedPort", cfg.LastHTTPServerNattedPort, "must be 0 or a port within StartingHTTPServerNattedPort-65535")
+	_, err = ParsePortRanges(cfg.DeniedHostPorts)
+	check(err == nil, "DeniedHostPorts", cfg.DeniedHostPorts, fmt.Sprintf("must list ports or port ranges: %v", err))
+	check(cfg.LaunchConcurrency >= 0, "LaunchConcurrency", cfg.LaunchConcurrency, "must not be negative")
+	check(cfg.LaunchRate >= 0, "LaunchRate", cfg.LaunchRate, "must not be negative")
+	check(cfg.LaunchProgressInterval >= 0, "LaunchProgressInterval", cfg.LaunchProgressInterval, "must not be negative")
+	check(cfg.LaunchAttempts > 0, "LaunchAttempts", cfg.LaunchAttempts, "must be at least 1")
+	check(cfg.LaunchRetryBackoff > 0, "LaunchRetryBackoff", cfg.LaunchRetryBackoff, "must be positive")
+	check(cfg.LaunchRetryMaxBackoff >= cfg.LaunchRetryBackoff, "LaunchRetryMaxBackoff", cfg.LaunchRetryMaxBackoff, "must not be less than LaunchRetryBackoff")
+	check(cfg.LaunchFailurePolicy == LaunchFailureAbort || cfg.LaunchFailurePolicy == LaunchFailureContinue || cfg.LaunchFailurePolicy == LaunchFailureRetry,
+		"LaunchFailurePolicy", cfg.LaunchFailurePolicy, "must be abort, continue or retry")
+	resources := cfg.ContainerResources
+	check(resources.CPUs >= 0, "ContainerResources.CPUs", resources.CPUs, "must not be negative")
+	check(resources.Memory >= 0, "ContainerResources.Memory", resources.Memory, "must not be negative")
+	check(resources.MemorySwap == 0 || resources.Memory > 0 && (resources.MemorySwap == -1 || resources.MemorySwap >= resources.Memory),
+		"ContainerResources.MemorySwap", resources.MemorySwap, "must be 0, or -1 or at least Memory with a Memory limit")
+	check(resources.PidsLimit >= 0, "ContainerResources.PidsLimit", resources.PidsLimit, "must not be negative")
+	_, err = ParseUlimits(resources.Ulimits)
+	check(err == nil, "ContainerResources.Ulimits", resources.Ulimits, fmt.Sprintf("must list name=soft[:hard] ulimits: %v", err))
+	switch resources.RestartPolicy {
+	case RestartPolicyNo, RestartPolicyOnFailure, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		// A daemon restart policy would race self-healing over the dead containers.
+		check(resources.RestartPolicy == RestartPolicyNo || cfg.MaxRestarts == 0, "ContainerResources.RestartPolicy", resources.RestartPolicy, "must be no unless self-healing is disabled with MaxRestarts 0")
+	default:
+		check(false, "ContainerResources.RestartPolicy", resources.RestartPolicy, "must be no, on-failure, always or unless-stopped")
+	}
+	check(resources.RestartMaxRetries >= 0, "ContainerResources.RestartMaxRetries", resources.RestartMaxRetries, "must not be negative")
+	check(resources.RestartMaxRetries == 0 || resources.RestartPolicy == RestartPolicyOnFailure, "ContainerResources.RestartMaxRetries", resources.RestartMaxRetries, "must be 0 unless RestartPolicy is on-failure")
+	check(cfg.LaunchTimeout >= 0, "LaunchTimeout", cfg.LaunchTimeout, "must not be negative")
+	check(cfg.StopTimeout >= 0, "StopTimeout", cfg.StopTimeout, "must not be negative")
+	check(cfg.BuildTimeout >= 0, "BuildTimeout", cfg.BuildTimeout, "must not be negative")
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validLogSink tells whether sink is stdout, file or a network sink URL.
+func validLogSink(sink string) bool {
+
+	if sink == LogSinkStdout || sink == LogSinkFile {
+		return true
+	}
+
+	sinkURL, err := url.Parse(sink)
+	if err != nil || sinkURL.Host == "" {
+		return false
+	}
+	for _, scheme := range LogSinkSchemes {
+		if sinkURL.Scheme == scheme {
+			return true
+		}
+	}
+
+	return false
+}
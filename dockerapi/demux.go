@@ -0,0 +1,230 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Stream names the container output stream of a log line.
+type Stream string
+
+// Container output streams.
+const (
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+// MaxLogLineSize bounds a log line. Longer lines are split into MaxLogLineSize chunks,
+// each prefixed by the daemon timestamp of the line, if any, see SplitLogTimestamp.
+const MaxLogLineSize = 1024 * 1024
+
+// Multiplexed frame header: stream type byte, 3 zero bytes, big endian uint32 payload size.
+// https://docs.docker.com/engine/api/v1.40/#operation/ContainerAttach
+const (
+	frameHeaderSize = 8
+	frameStdin      = 0
+	frameStdout     = 1
+	frameStderr     = 2
+	frameSystemErr  = 3
+	readChunkSize   = 32 * 1024
+)
+
+// LogLine is a line of a container's output without its line ending.
+type LogLine struct {
+	Stream Stream
+	Text   string
+}
+
+// LogLineReader reads the lines of a container log stream.
+// The stream of a non TTY container multiplexes stdout and stderr frames whose lines
+// may span frames. The stream of a TTY container is raw stdout.
+type LogLineReader struct {
+	reader  io.Reader
+	tty     bool
+	chunk   []byte
+	partial map[Stream][]byte
+	// stamps holds the daemon timestamp prefix of each stream's line being split into chunks.
+	stamps map[Stream][]byte
+	lines  []LogLine
+	err    error
+}
+
+// NewLogLineReader returns a LogLineReader of the log stream of a container in tty mode or not.
+func NewLogLineReader(reader io.Reader, tty bool) *LogLineReader {
+
+	return &LogLineReader{
+		reader:  reader,
+		tty:     tty,
+		chunk:   make([]byte, readChunkSize),
+		partial: map[Stream][]byte{},
+		stamps:  map[Stream][]byte{},
+	}
+}
+
+// ReadLine returns the next line. At the end of the stream it returns the unterminated
+// remainder of each stream as a line, then io.EOF.
+// A stream ending within a frame returns io.ErrUnexpectedEOF.
+func (r *LogLineReader) ReadLine() (LogLine, error) {
+
+	for len(r.lines) == 0 {
+		if r.err != nil {
+			return LogLine{}, r.err
+		}
+		if r.err = r.fill(); r.err != nil {
+			r.flush()
+		}
+	}
+
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+
+	return line, nil
+}
+
+// fill reads the next chunk of the stream into lines.
+func (r *LogLineReader) fill() error {
+
+	if r.tty {
+		n, err := r.reader.Read(r.chunk)
+		r.split(StreamStdout, r.chunk[:n])
+		return err
+	}
+
+	header := r.chunk[:frameHeaderSize]
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return err
+	}
+
+	var stream Stream
+	switch header[0] {
+	case frameStdin, frameStdout:
+		stream = StreamStdout
+	case frameStderr:
+		stream = StreamStderr
+	case frameSystemErr:
+		stream = ""
+	default:
+		return fmt.Errorf("unexpected log stream type %d, is the container in TTY mode?", header[0])
+	}
+
+	var systemErr []byte
+	for remaining := int(binary.BigEndian.Uint32(header[4:])); remaining > 0; {
+		size := remaining
+		if size > len(r.chunk) {
+			size = len(r.chunk)
+		}
+		n, err := io.ReadFull(r.reader, r.chunk[:size])
+		if stream == "" {
+			systemErr = append(systemErr, r.chunk[:n]...)
+		} else {
+			r.split(stream, r.chunk[:n])
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+
+	if stream == "" {
+		return errors.New(string(systemErr))
+	}
+
+	return nil
+}
+
+// split appends the complete lines of data to lines and keeps the remainder as the stream's partial line.
+func (r *LogLineReader) split(stream Stream, data []byte) {
+
+	partial := r.partial[stream]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			partial = append(partial, data...)
+			break
+		}
+		partial = append(partial, data[:i]...)
+		r.emit(stream, partial)
+		partial = partial[:0]
+		data = data[i+1:]
+	}
+
+	for len(partial) > MaxLogLineSize {
+		r.emitChunk(stream, partial[:MaxLogLineSize], false)
+		partial = partial[MaxLogLineSize:]
+	}
+	r.partial[stream] = partial
+}
+
+// emit appends line to lines in MaxLogLineSize chunks.
+func (r *LogLineReader) emit(stream Stream, line []byte) {
+
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	for len(line) > MaxLogLineSize {
+		r.emitChunk(stream, line[:MaxLogLineSize], false)
+		line = line[MaxLogLineSize:]
+	}
+	r.emitChunk(stream, line, true)
+}
+
+// emitChunk appends a chunk of a line to lines, the last one or not. The chunks following the first
+// are prefixed by the daemon timestamp prefixing the first so that every chunk keeps the time of the line.
+func (r *LogLineReader) emitChunk(stream Stream, chunk []byte, last bool) {
+
+	stamp, continued := r.stamps[stream]
+	text := string(stamp) + string(chunk)
+	if !continued {
+		text = string(chunk)
+		if i := bytes.IndexByte(chunk, ' '); i > 0 {
+			if _, err := time.Parse(time.RFC3339Nano, string(chunk[:i])); err == nil {
+				stamp = []byte(text[:i+1])
+			}
+		}
+	}
+	r.lines = append(r.lines, LogLine{Stream: stream, Text: text})
+
+	if last {
+		delete(r.stamps, stream)
+		return
+	}
+	r.stamps[stream] = stamp
+}
+
+// flush emits the unterminated remainder of each stream.
+func (r *LogLineReader) flush() {
+
+	for _, stream := range []Stream{StreamStdout, StreamStderr} {
+		if partial := r.partial[stream]; len(partial) > 0 {
+			r.emit(stream, partial)
+			r.partial[stream] = nil
+		}
+	}
+}
+
+// SplitLogTimestamp splits the RFC 3339 daemon timestamp prefixing a line of a log stream
+// requested with Timestamps from the message. ok is false for a line without timestamp.
+func SplitLogTimestamp(text string) (timestamp time.Time, message string, ok bool) {
+
+	i := strings.IndexByte(text, ' ')
+	if i < 0 {
+		i = len(text)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, text[:i])
+	if err != nil {
+		return time.Time{}, text, false
+	}
+	if i < len(text) {
+		i++
+	}
+
+	return timestamp, text[i:], true
+}
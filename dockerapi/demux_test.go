@@ -0,0 +1,161 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frame returns a multiplexed log frame of payload on the streamType stream.
+func frame(streamType byte, payload string) []byte {
+
+	header := []byte{streamType, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	return append(header, payload...)
+}
+
+// readLines reads all the lines of a log stream and its terminating error.
+func readLines(reader io.Reader, tty bool) ([]LogLine, error) {
+
+	lineReader := NewLogLineReader(reader, tty)
+	lines := []LogLine{}
+	for {
+		line, err := lineReader.ReadLine()
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func Test_LogLineReader_Demultiplexes_Frames(t *testing.T) {
+
+	var stream bytes.Buffer
+	stream.Write(frame(frameStdout, "first\nsecond\nspl"))
+	stream.Write(frame(frameStderr, "oops\n"))
+	stream.Write(frame(frameStdout, "it\n"))
+	stream.Write(frame(frameStdout, "a"))
+	stream.Write(frame(frameStderr, "no newline"))
+
+	lines, err := readLines(&stream, false)
+	if err != io.EOF {
+		t.Fatalf("ReadLine() error = %v, want io.EOF", err)
+	}
+
+	want := []LogLine{
+		{StreamStdout, "first"},
+		{StreamStdout, "second"},
+		{StreamStderr, "oops"},
+		{StreamStdout, "split"},
+		{StreamStdout, "a"},
+		{StreamStderr, "no newline"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("ReadLine() read %v, want %v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %v, want %v", i, lines[i], want[i])
+		}
+	}
+}
+
+func Test_LogLineReader_Reads_Long_Lines(t *testing.T) {
+
+	long := strings.Repeat("x", 100*1024)
+	huge := strings.Repeat("y", MaxLogLineSize+10)
+
+	var stream bytes.Buffer
+	stream.Write(frame(frameStdout, long[:70*1024]))
+	stream.Write(frame(frameStdout, long[70*1024:]+"\n"))
+	stream.Write(frame(frameStdout, huge+"\n"))
+
+	lines, err := readLines(&stream, false)
+	if err != io.EOF {
+		t.Fatalf("ReadLine() error = %v, want io.EOF", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("ReadLine() read %d lines, want 3", len(lines))
+	}
+	if lines[0].Text != long {
+		t.Errorf("line 0 has %d bytes, want %d", len(lines[0].Text), len(long))
+	}
+	if lines[1].Text != huge[:MaxLogLineSize] || lines[2].Text != huge[MaxLogLineSize:] {
+		t.Errorf("lines 1 and 2 have %d and %d bytes, want %d and 10", len(lines[1].Text), len(lines[2].Text), MaxLogLineSize)
+	}
+}
+
+func Test_LogLineReader_Long_Line_Chunks_Keep_The_Timestamp(t *testing.T) {
+
+	stamp := "2026-01-02T03:04:05.123456789Z "
+	huge := stamp + strings.Repeat("y", 2*MaxLogLineSize)
+
+	// A TTY stream splits the huge line before reading its end.
+	lines, err := readLines(strings.NewReader(huge+"\nnext\n"), true)
+	if err != io.EOF {
+		t.Fatalf("ReadLine() error = %v, want io.EOF", err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("ReadLine() read %d lines, want 4", len(lines))
+	}
+	for i, line := range lines[:3] {
+		if !strings.HasPrefix(line.Text, stamp) {
+			t.Errorf("chunk %d starts with %.40q, want the line's timestamp", i, line.Text)
+		}
+	}
+	if text := lines[0].Text + lines[1].Text[len(stamp):] + lines[2].Text[len(stamp):]; text != huge {
+		t.Errorf("chunks join to %d bytes, want %d", len(text), len(huge))
+	}
+	if lines[3].Text != "next" {
+		t.Errorf("line after the chunks = %q, want next without timestamp", lines[3].Text)
+	}
+}
+
+func Test_LogLineReader_TTY_Is_Raw_Stdout(t *testing.T) {
+
+	lines, err := readLines(strings.NewReader("one\r\ntwo\r\n"), true)
+	if err != io.EOF {
+		t.Fatalf("ReadLine() error = %v, want io.EOF", err)
+	}
+	if len(lines) != 2 || lines[0] != (LogLine{StreamStdout, "one"}) || lines[1] != (LogLine{StreamStdout, "two"}) {
+		t.Errorf("ReadLine() read %v, want stdout one and two", lines)
+	}
+
+	// A TTY stream read as multiplexed is reported rather than garbled.
+	if _, err = readLines(strings.NewReader("one\r\ntwo\r\n"), false); err == nil || err == io.EOF {
+		t.Errorf("ReadLine() of a TTY stream in multiplexed mode error = %v, want an unexpected stream type", err)
+	}
+}
+
+func Test_LogLineReader_Truncated_Frame(t *testing.T) {
+
+	truncated := frame(frameStdout, "complete\npartial line")
+	lines, err := readLines(bytes.NewReader(truncated[:len(truncated)-5]), false)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadLine() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(lines) != 2 || lines[0].Text != "complete" || lines[1].Text != "partial" {
+		t.Errorf("ReadLine() read %v, want complete and partial", lines)
+	}
+}
+
+func Test_SplitLogTimestamp(t *testing.T) {
+
+	timestamp, message, ok := SplitLogTimestamp("2026-01-02T03:04:05.123456789Z GET /1/2/3/")
+	if !ok || message != "GET /1/2/3/" || !timestamp.Equal(time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC)) {
+		t.Errorf("SplitLogTimestamp() = %v, %q, %v", timestamp, message, ok)
+	}
+
+	if _, message, ok = SplitLogTimestamp("2026-01-02T03:04:05Z"); !ok || message != "" {
+		t.Errorf("SplitLogTimestamp() of an empty line = %q, %v, want \"\", true", message, ok)
+	}
+
+	if _, message, ok = SplitLogTimestamp("GET /1/2/3/"); ok || message != "GET /1/2/3/" {
+		t.Errorf("SplitLogTimestamp() of a line without timestamp = %q, %v, want the whole line, false", message, ok)
+	}
+}
@@ -2,15 +2,18 @@
 package dockerapi
 
 import (
-	"sync"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"tlex/mapsi2disk"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tlex/state"
 
-	"golang.org/x/sync/errgroup"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -18,6 +21,7 @@ import (
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/go-connections/nat"
+	"golang.org/x/sync/errgroup"
 )
 
 const containerRunningStateString string = "running"
@@ -26,53 +30,98 @@ const containerRunningStateString string = "running"
 type OwnedContainers map[string]int
 
 // ContainerReaderStream contains a container reader stream, host port mapped to the container http server.
+// TTY is set for the log stream of a TTY container, which is not multiplexed.
 type ContainerReaderStream struct {
-	ReaderStream io.ReadCloser
-	HostPort     int
+	ReaderStream  io.ReadCloser
+	HostPort      int
+	ContainerID   string
+	ContainerName string
+	TTY           bool
 }
 
-// Cleanup previous owned live instances that might have been left hanging.
-func RemoveLiveContainersFromPreviousRun() {
+// LoadOwnedContainers reads the containers owned by a previous launch from the state file.
+func LoadOwnedContainers() (OwnedContainers, error) {
+
+	launchState, err := state.Load(state.Filename)
+	if err != nil {
+		return nil, err
+	}
 
-	readObj, err := mapsi2disk.ReadContainerPortsFromDisk(mapsi2disk.GobFilename)
-	readBackOwnedContainers := readObj.(map[string]int)
+	owned := make(OwnedContainers, len(launchState.Containers))
+	for _, cont := range launchState.Containers {
+		owned[cont.ID] = cont.HostPort
+	}
 
-	if err == nil {
-		defer mapsi2disk.DeleteFile(mapsi2disk.GobFilename)
+	return owned, nil
+}
 
-		dockerClient := GetDockerClient()
+// RemoveLiveContainersFromPreviousRun cleans up the live instances of the owner's previous launch that might have
+// been left hanging. The instances are found by the owner's labels, so those relaunched since the launch was
+// recorded are found too. Containers already gone are skipped. Each stop request is bounded by stopTimeout.
+func RemoveLiveContainersFromPreviousRun(ctx context.Context, dockerClient ContainerEngine, owner Owner, stopTimeout time.Duration) error {
 
-		for containerID := range readBackOwnedContainers {
-			log.Printf("Deleting container: %v from previous launch.\n", containerID)
-			err = dockerClient.ContainerStop(context.Background(), containerID, nil)
+	containers, err := owner.ListContainers(ctx, dockerClient)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		log.Printf("Deleting container: %v from previous launch %s.\n", container.ID, container.Labels[LabelRunID])
+		err = stopContainer(ctx, dockerClient, container.ID, stopTimeout)
+		if err != nil && !errors.Is(err, ErrContainerNotRunning) {
+			return &ContainerError{Op: "stop", ContainerID: container.ID, HostPort: labeledHostPort(container), Err: err}
 		}
 	}
+
+	return nil
+}
+
+// StopOwnedContainers stops the owned containers by ID e.g. those recorded by a legacy ids.gob without a run id.
+// Containers already gone are skipped. Each stop request is bounded by stopTimeout.
+func (owned OwnedContainers) StopOwnedContainers(ctx context.Context, dockerClient ContainerEngine, stopTimeout time.Duration) error {
+
+	for containerID, hostPort := range owned {
+		log.Printf("Deleting container: %v from a previous launch.\n", containerID)
+		err := stopContainer(ctx, dockerClient, containerID, stopTimeout)
+		if err != nil && !errors.Is(err, ErrContainerNotRunning) {
+			return &ContainerError{Op: "stop", ContainerID: containerID, HostPort: hostPort, Err: err}
+		}
+	}
+
+	return nil
 }
 
 // GetDockerClient returns a docker remote api client handle value foundational to all Docker remote api interactions.
-// Upon error it panics.
+// Upon error it returns ErrDaemonUnreachable.
 // This process creates a docker client when launching and holds on to it for all API interactions.
 // This should be contrasted with the stateless approach of requesting a new client for any API interaction.
-func GetDockerClient() *client.Client {
+func GetDockerClient(ctx context.Context) (*client.Client, error) {
 
-	ctx := context.Background()
 	dockerClient, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
-		log.Panicf("Docker client.NewClientWithOpts error: %s\n", err)
+		return nil, &engineError{ErrDaemonUnreachable, err}
+	}
+	if _, err = dockerClient.Ping(ctx); err != nil {
+		dockerClient.Close()
+		return nil, &engineError{ErrDaemonUnreachable, err}
 	}
 	dockerClient.NegotiateAPIVersion(ctx)
 
-	return dockerClient
+	return dockerClient, nil
 }
 
-// BuildDockerImage builds a Docker Image for a given dockerFilePath located in the same folder
+// BuildDockerImage builds a Docker Image tagged imageName for a given dockerFilePath located in the same folder
 // of the running process.
-// Upon Error it exits process.
-func BuildDockerImage(dockerClient *client.Client, dockerFilePath string) {
+// The build including its output streaming is bounded by buildTimeout.
+// Upon error including a failed build step it returns it.
+func BuildDockerImage(ctx context.Context, dockerClient ContainerEngine, dockerFilePath string, imageName string, buildTimeout time.Duration) error {
+
+	ctx, cancel := WithTimeout(ctx, buildTimeout)
+	defer cancel()
 
 	tarDockerfileReader, err := archive.TarWithOptions(dockerFilePath, &archive.TarOptions{})
 	if err != nil {
-		log.Fatal(err, " :unable to create tar with Dockerfile")
+		return fmt.Errorf("unable to create tar with Dockerfile %s: %w", dockerFilePath, err)
 	}
 	log.Printf("Building Docker Image in %q\n", dockerFilePath)
 	options := types.ImageBuildOptions{
@@ -80,26 +129,31 @@ func BuildDockerImage(dockerClient *client.Client, dockerFilePath string) {
 		Remove:         true,
 		ForceRemove:    true,
 		PullParent:     true,
-		Tags:           []string{"mariohellowebserver"},
+		Tags:           []string{imageName},
 		Dockerfile:     "Dockerfile",
 	}
-	buildResponse, err := dockerClient.ImageBuild(context.Background(), tarDockerfileReader, options)
+	buildResponse, err := dockerClient.ImageBuild(ctx, tarDockerfileReader, options)
 	if err != nil {
-		log.Fatal(err, " :unable to read image build response")
+		return fmt.Errorf("unable to read image build response: %w", classify(err))
 	}
 	defer buildResponse.Body.Close()
 
 	termFd, isTerm := term.GetFdInfo(os.Stderr)
-	jsonmessage.DisplayJSONMessagesStream(buildResponse.Body, os.Stderr, termFd, isTerm, nil)
+	if err = jsonmessage.DisplayJSONMessagesStream(buildResponse.Body, os.Stderr, termFd, isTerm, nil); err != nil {
+		return fmt.Errorf("image build failed: %w", classify(err))
+	}
+
+	return nil
 }
 
-// GetContainersLogReaders gets our running containers' log readers.
-// Upon failure, it panics.
-func (owned OwnedContainers) GetContainersLogReaders(dockerClient *client.Client) []ContainerReaderStream {
+// GetContainersLogReaders gets our running containers' log readers, which end when ctx is done.
+// Every line is prefixed by its daemon RFC 3339 timestamp, see SplitLogTimestamp.
+// Upon failure, it closes the readers opened so far and returns the error.
+func (owned OwnedContainers) GetContainersLogReaders(ctx context.Context, dockerClient ContainerEngine) ([]ContainerReaderStream, error) {
 
-	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	containers, err := getContainers(ctx, dockerClient)
 	if err != nil {
-		log.Panicf("Unable to list containers. Error: %v", err)
+		return nil, err
 	}
 
 	containerLogStreams := []ContainerReaderStream{}
@@ -107,142 +161,308 @@ func (owned OwnedContainers) GetContainersLogReaders(dockerClient *client.Client
 	for _, container := range containers {
 		hostPort := owned[container.ID]
 		if hostPort > 0 && container.State == containerRunningStateString {
-			readerStream, err := dockerClient.ContainerLogs(context.Background(), container.ID, types.ContainerLogsOptions{
-				ShowStdout: true,
-				ShowStderr: true,
-				Follow:     true,
-			})
+			containerLogStream, err := OpenLogStream(ctx, dockerClient, container.ID, hostPort, time.Time{})
 			if err != nil {
-				log.Panicf("Unable to solicit a log reader from the container %s, error: %s\n", container.ID, err)
+				closeReaderStreams(containerLogStreams)
+				return nil, err
 			}
-
-			containerLogStream := ContainerReaderStream{readerStream, hostPort}
 			containerLogStreams = append(containerLogStreams, containerLogStream)
 		}
 	}
 
-	return containerLogStreams
+	return containerLogStreams, nil
+}
+
+// OpenLogStream opens the timestamped log stream of a container from since on, or from its start
+// when since is zero. The stream ends when ctx is done or the container stops.
+func OpenLogStream(ctx context.Context, dockerClient ContainerEngine, containerID string, hostPort int, since time.Time) (ContainerReaderStream, error) {
+
+	inspect, err := dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return ContainerReaderStream{}, &ContainerError{Op: "inspect", ContainerID: containerID, HostPort: hostPort, Err: classify(err)}
+	}
+	tty := inspect.Config != nil && inspect.Config.Tty
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	}
+	if !since.IsZero() {
+		options.Since = since.Format(time.RFC3339Nano)
+	}
+	readerStream, err := dockerClient.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		return ContainerReaderStream{}, &ContainerError{Op: "logs", ContainerID: containerID, HostPort: hostPort, Err: classify(err)}
+	}
+
+	name := ""
+	if inspect.ContainerJSONBase != nil {
+		name = strings.TrimPrefix(inspect.Name, "/")
+	}
+
+	return ContainerReaderStream{readerStream, hostPort, containerID, name, tty}, nil
+}
+
+// OpenStatsStream opens the stats stream of a container, which ends when ctx is done or the container stops.
+func OpenStatsStream(ctx context.Context, dockerClient ContainerEngine, containerID string, hostPort int) (ContainerReaderStream, error) {
+
+	out, err := dockerClient.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		return ContainerReaderStream{}, &ContainerError{Op: "stats", ContainerID: containerID, HostPort: hostPort, Err: classify(err)}
+	}
+
+	return ContainerReaderStream{out.Body, hostPort, containerID, "", false}, nil
+}
+
+// ContainerExited inspects a container and tells whether it is no longer running and its exit code.
+// A removed container, as an auto removed one, exited with the unknown exit code -1.
+func ContainerExited(ctx context.Context, dockerClient ContainerEngine, containerID string) (exited bool, exitCode int, err error) {
+
+	inspect, err := dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		err = classify(err)
+		if errors.Is(err, ErrContainerNotRunning) {
+			return true, -1, nil
+		}
+		return false, 0, err
+	}
+	if inspect.ContainerJSONBase == nil || inspect.State == nil || inspect.State.Running {
+		return false, 0, nil
+	}
+
+	return true, inspect.State.ExitCode, nil
+}
+
+// closeReaderStreams closes the streams of a partially failed request.
+func closeReaderStreams(streams []ContainerReaderStream) {
+
+	for _, stream := range streams {
+		stream.ReaderStream.Close()
+	}
+}
+
+// WithTimeout bounds ctx by timeout unless timeout is 0.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stopContainer stops a container bounding the request by stopTimeout.
+// It then removes the container unless the daemon auto removes it, e.g. one with a restart policy,
+// so that it is not left exited holding its name.
+func stopContainer(ctx context.Context, dockerClient ContainerEngine, containerID string, stopTimeout time.Duration) error {
+
+	ctx, cancel := WithTimeout(ctx, stopTimeout)
+	defer cancel()
+
+	if err := classify(dockerClient.ContainerStop(ctx, containerID, nil)); err != nil {
+		return err
+	}
+
+	inspect, err := dockerClient.ContainerInspect(ctx, containerID)
+	switch err = classify(err); {
+	case errors.Is(err, ErrContainerNotRunning):
+		// Auto removed already.
+		return nil
+	case err != nil:
+		return err
+	case inspect.ContainerJSONBase == nil || inspect.HostConfig == nil || inspect.HostConfig.AutoRemove:
+		return nil
+	}
+
+	return removeContainer(ctx, dockerClient, containerID)
+}
+
+// containerName returns the container's name without the leading slash.
+func containerName(container types.Container) string {
+
+	if len(container.Names) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(container.Names[0], "/")
 }
 
 // getContainers lists all the containers running on host machine.
-func getContainers(dockerClient *client.Client) ([]types.Container, error) {
+func getContainers(ctx context.Context, dockerClient ContainerEngine) ([]types.Container, error) {
 
-	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
-		log.Printf("Unable to list containers: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("unable to list containers: %w", classify(err))
 	}
 
 	return containers, nil
 }
 
+// ListOwnedContainers lists the owned containers known to the daemon in any state.
+// Owned containers missing from the list are gone i.e. auto removed after stopping.
+func (owned OwnedContainers) ListOwnedContainers(ctx context.Context, dockerClient ContainerEngine) ([]types.Container, error) {
+
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list containers: %w", classify(err))
+	}
+
+	ownedList := []types.Container{}
+	for _, container := range containers {
+		if _, ok := owned[container.ID]; ok {
+			ownedList = append(ownedList, container)
+		}
+	}
+
+	return ownedList, nil
+}
+
+// RemoveStaleContainers removes the owner's HttpServerAt_* containers left created or exited, e.g. by an
+// interrupted launch, so that they do not hold their names. Each removal is bounded by timeout.
+// It returns ErrNoRunID for an owner without a run id.
+func (owner Owner) RemoveStaleContainers(ctx context.Context, dockerClient ContainerEngine, timeout time.Duration) error {
+
+	if owner.RunID == "" {
+		return ErrNoRunID
+	}
+
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: owner.filters()})
+	if err != nil {
+		return fmt.Errorf("unable to list containers: %w", classify(err))
+	}
+
+	for _, container := range containers {
+		hostPort := labeledHostPort(container)
+		if container.State == containerRunningStateString || !strings.HasPrefix(containerName(container), "HttpServerAt_") || !owner.selects(hostPort) {
+			continue
+		}
+		log.Printf("Removing the stale container %s from launch %s.\n", containerName(container), container.Labels[LabelRunID])
+		removeCtx, cancel := WithTimeout(ctx, timeout)
+		err = removeContainer(removeCtx, dockerClient, container.ID)
+		cancel()
+		if err != nil && !errors.Is(err, ErrContainerNotRunning) {
+			return &ContainerError{Op: "remove", ContainerID: container.ID, HostPort: hostPort, Err: err}
+		}
+	}
+
+	return nil
+}
+
 // CleanLeftOverContainers stops any *owned* live containers.
 // Useful in during lauching of containers fails and have to clean up launched instances.
-func (owned OwnedContainers)CleanLeftOverContainers(dockerClient *client.Client) {
+// It attempts every container, each bounded by stopTimeout, and returns the first error.
+func (owner Owner) CleanLeftOverContainers(ctx context.Context, dockerClient ContainerEngine, stopTimeout time.Duration) error {
 
-	containers, err := getContainers(dockerClient)
-	if err == nil {
-		for _, container := range containers {
-			if _, ok := owned[container.ID]; ok {
-				err = dockerClient.ContainerStop(context.Background(), container.ID, nil)
-			}
+	containers, err := owner.ListContainers(ctx, dockerClient)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, container := range containers {
+		err = stopContainer(ctx, dockerClient, container.ID, stopTimeout)
+		if err != nil && firstErr == nil {
+			firstErr = &ContainerError{Op: "stop", ContainerID: container.ID, HostPort: labeledHostPort(container), Err: err}
 		}
 	}
+
+	return firstErr
 }
 
 // AssertOwnedContainersAreLive lists all the containers running on the host
 // and asserts
 // 1. Existence of enough live containers
 // 2. This process' owned containers are live.
-// It panics otherwise.
-func (owned OwnedContainers) AssertOwnedContainersAreLive(requestedLiveContainers int, cli *client.Client) error {
+// It returns ErrNotEnoughContainers or ErrContainerNotRunning otherwise.
+func (owned OwnedContainers) AssertOwnedContainersAreLive(ctx context.Context, requestedLiveContainers int, cli ContainerEngine) error {
 
-	containers, err := getContainers(cli)
+	containers, err := getContainers(ctx, cli)
 	if err != nil {
-		log.Panicf("Cannot access live containers...\n")
+		return err
 	}
 
 	containersCount := len(containers)
 
 	if requestedLiveContainers > containersCount {
-		log.Panicf("Not enough containers...should be %d containers but found %d.\n", requestedLiveContainers, containersCount)
+		return fmt.Errorf("%w: should be %d containers but found %d", ErrNotEnoughContainers, requestedLiveContainers, containersCount)
 	}
 
 	// Assert owned containers are running
+	running := make(map[string]types.Container, containersCount)
 	for _, container := range containers {
-		if owned[container.ID] > 0 && container.State == containerRunningStateString {
-			log.Printf("Container %s in %s state.\n", container.ID, container.State)
-		} else {
-			log.Panicf("Found container %s that is not running with state %s, status %s.\n", container.ID, container.State, container.Status)
+		running[container.ID] = container
+	}
+	for containerID, hostPort := range owned {
+		container, ok := running[containerID]
+		if !ok || container.State != containerRunningStateString {
+			return &ContainerError{Op: "assert", ContainerID: containerID, HostPort: hostPort, Err: ErrContainerNotRunning}
 		}
+		log.Printf("Container %s in %s state.\n", container.ID, container.State)
 	}
 
 	return nil
 }
 
 // AssertRequestedContainersAreLive lists all the containers running on the host
-// and asserts that the intended containers number is live otherwise it panics.
+// and asserts that the intended containers number is live otherwise it returns an error.
 // This is called by tests. AssertOwnedContainersAreLive is called by default within workflow
-func AssertRequestedContainersAreLive(requestedLiveContainers int) {
-
-	cli := GetDockerClient()
+func AssertRequestedContainersAreLive(ctx context.Context, requestedLiveContainers int, cli ContainerEngine) error {
 
-	containers, err := getContainers(cli)
+	containers, err := getContainers(ctx, cli)
 	if err != nil {
-		log.Panicf("Cannot access live containers...\n")
+		return err
 	}
 
 	containersCount := len(containers)
 
 	if requestedLiveContainers > containersCount {
-		log.Panicf("Not enough containers...Wanted %d containers but got %d.\n", requestedLiveContainers, containersCount)
+		return fmt.Errorf("%w: wanted %d containers but got %d", ErrNotEnoughContainers, requestedLiveContainers, containersCount)
 	}
 
 	// Assert owned containers are running
 	for _, container := range containers {
-		if container.State == containerRunningStateString {
-			log.Printf("Container %s in %s state.\n", container.ID, container.State)
-		} else {
-			log.Panicf("Found container %s that is not running with state %s, status %s.\n", container.ID, container.State, container.Status)
+		if container.State != containerRunningStateString {
+			return &ContainerError{Op: "assert", ContainerID: container.ID, Err: fmt.Errorf("%w: state %s, status %s", ErrContainerNotRunning, container.State, container.Status)}
 		}
+		log.Printf("Container %s in %s state.\n", container.ID, container.State)
 	}
 
 	fmt.Printf("\n**** Passed RequestedContainers == Live assertion. ****\n\n")
+
+	return nil
 }
 
-// AssertRequestedContainersAreGone check that no containers exist and that the system cleaned up otherwise it panics.
+// AssertRequestedContainersAreGone check that no containers exist and that the system cleaned up otherwise it returns ErrContainersStillLive.
 // This is called by tests.
-func AssertRequestedContainersAreGone() {
+func AssertRequestedContainersAreGone(ctx context.Context, cli ContainerEngine) error {
 
-	cli := GetDockerClient()
-
-	containers, err := getContainers(cli)
+	containers, err := getContainers(ctx, cli)
 	if err != nil {
-		log.Panicf("Cannot access live containers...\n")
-	}
-
-	containersCount := len(containers)
-
-	if containersCount > 0 {
-		log.Printf("Containers are still alive... Wanted 0 containers but got %d.\n", containersCount)
+		return err
 	}
 
 	// Assert owned containers are not running
 	for _, container := range containers {
-		log.Panicf("Found container %s with state %s, status %s.\n", container.ID, container.State, container.Status)
+		log.Printf("Found container %s with state %s, status %s.\n", container.ID, container.State, container.Status)
+	}
+	if containersCount := len(containers); containersCount > 0 {
+		return fmt.Errorf("%w: wanted 0 containers but got %d", ErrContainersStillLive, containersCount)
 	}
 
 	fmt.Printf("\n**** Passed RequestedContainers == 0 assertion. ****\n\n")
+
+	return nil
 }
 
-// GetContainersStatsReaders gets our running containers' resources readers
-// Upon error it panics.
-func (owned OwnedContainers) GetContainersStatsReaders(dockerClient *client.Client) []ContainerReaderStream {
+// GetContainersStatsReaders gets our running containers' resources readers, which end when ctx is done.
+// Upon failure, it closes the readers opened so far and returns the error.
+func (owned OwnedContainers) GetContainersStatsReaders(ctx context.Context, dockerClient ContainerEngine) ([]ContainerReaderStream, error) {
 
-	dockerClient = GetDockerClient()
-	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	containers, err := getContainers(ctx, dockerClient)
 	if err != nil {
-		log.Panicf("Unable to list containers. Error: %v", err)
+		return nil, err
 	}
 
 	containerStatsStreams := []ContainerReaderStream{}
@@ -250,112 +470,181 @@ func (owned OwnedContainers) GetContainersStatsReaders(dockerClient *client.Clie
 	for _, container := range containers {
 		hostPort := owned[container.ID]
 		if hostPort > 0 && container.State == containerRunningStateString {
-			out, err := dockerClient.ContainerStats(context.Background(), container.ID, true)
+			containerStatsStream, err := OpenStatsStream(ctx, dockerClient, container.ID, hostPort)
 			if err != nil {
-				log.Panicf("Unable to solicit a monitoring reader from the container %s, error: %s\n", container.ID, err)
+				closeReaderStreams(containerStatsStreams)
+				return nil, err
 			}
-
-			containerStatsStream := ContainerReaderStream{out.Body, hostPort}
+			containerStatsStream.ContainerName = containerName(container)
 			containerStatsStreams = append(containerStatsStreams, containerStatsStream)
 		}
 	}
 
-	return containerStatsStreams
+	return containerStatsStreams, nil
 }
 
-// StopAllLiveContainers stops as many live containers as possible
-func (owned OwnedContainers) StopAllLiveContainers(terminatorGroup *sync.WaitGroup, dockerClient *client.Client) {
+// StopAllLiveContainers stops as many live containers as possible, each bounded by stopTimeout.
+// It returns the listing error; individual stop failures are logged.
+func (owner Owner) StopAllLiveContainers(ctx context.Context, terminatorGroup *sync.WaitGroup, dockerClient ContainerEngine, stopTimeout time.Duration) error {
 
-	containers, err := getContainers(dockerClient)
-	if err == nil {
+	containers, err := owner.ListContainers(ctx, dockerClient)
+	if err != nil {
+		return err
+	}
 
-		for _, container := range containers {
-			if owned[container.ID] > 0 {
+	for _, container := range containers {
 
-				contID := container.ID
+		contID := container.ID
 
-				terminatorGroup.Add(1)
+		terminatorGroup.Add(1)
 
-				go func() {
-					err = dockerClient.ContainerStop(context.Background(), contID, nil)
-					if err != nil {
-						log.Printf("Stopping container failed: %v\n", err)
-					} else {
-						log.Printf("Stopped container with ID: %s\n", contID)
-					}
-					defer terminatorGroup.Done()
-				}()
+		go func() {
+			err := stopContainer(ctx, dockerClient, contID, stopTimeout)
+			if err != nil {
+				log.Printf("Stopping container failed: %v\n", err)
+			} else {
+				log.Printf("Stopped container with ID: %s\n", contID)
 			}
-		}
+			defer terminatorGroup.Done()
+		}()
 	}
+
+	return nil
 }
 
-// createContainer creates a new container for the dockerImageName
+// createContainer creates a new container named name for the dockerImageName
 // at the container httpServerContainerPort value.
-// and at the host httpServerHostPort value.
+// and at the host httpServerHostPort value labeled as owned by runID, or at a host port chosen by Docker when 0,
+// within limits unless nil.
 // Returns the new container's struct abstraction, error.
-// Upon error it panics.
 // Credit: https://medium.com/tarkalabs/controlling-the-docker-engine-in-go-826012f9671c
-func createContainer(dockerClient *client.Client, dockerImageName string, httpServerContainerPort int, httpServerHostPort int) (container.ContainerCreateCreatedBody, error) {
+func createContainer(ctx context.Context, dockerClient ContainerEngine, runID string, dockerImageName string, limits *ContainerLimits, name string, httpServerContainerPort int, httpServerHostPort int) (container.ContainerCreateCreatedBody, error) {
 
 	hostBinding := nat.PortBinding{
-		HostIP:   "0.0.0.0",
-		HostPort: fmt.Sprintf("%d", httpServerHostPort),
+		HostIP: "0.0.0.0",
+	}
+	if httpServerHostPort > 0 {
+		hostBinding.HostPort = fmt.Sprintf("%d", httpServerHostPort)
 	}
 	containerPort, err := nat.NewPort("tcp", fmt.Sprintf("%d", httpServerContainerPort))
 	if err != nil {
-		log.Panicf("Unable to create a tcp httpServerContainerPort %d\n", httpServerContainerPort)
+		return container.ContainerCreateCreatedBody{}, fmt.Errorf("unable to create a tcp httpServerContainerPort %d: %w", httpServerContainerPort, err)
 	}
 
 	portBinding := nat.PortMap{containerPort: []nat.PortBinding{hostBinding}}
-	containerBody, err := dockerClient.ContainerCreate(context.Background(),
-		&container.Config{Image: dockerImageName},
-		&container.HostConfig{
-			PortBindings: portBinding,
-			AutoRemove:   true,
-		},
+	containerBody, err := dockerClient.ContainerCreate(ctx,
+		&container.Config{Image: dockerImageName, Labels: containerLabels(runID, dockerImageName, httpServerHostPort)},
+		limits.hostConfig(portBinding),
 		nil,
-		fmt.Sprintf("HttpServerAt_%d", httpServerHostPort))
-	if err != nil {
-		log.Panicf("ContainerCreate failed for the image: %s, host port: %d with error: %s\n", dockerImageName, httpServerContainerPort, err)
+		name)
+
+	return containerBody, classify(err)
+}
+
+// removeContainer force removes a container, stopping it if need be.
+func removeContainer(ctx context.Context, dockerClient ContainerEngine, containerID string) error {
+
+	return classify(dockerClient.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{Force: true}))
+}
+
+// removeFailedContainer removes a container failing to launch so that it does not hold its name.
+// The removal outlives the launch context and is bounded by timeout.
+func removeFailedContainer(dockerClient ContainerEngine, containerID string, timeout time.Duration) {
+
+	ctx, cancel := WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := removeContainer(ctx, dockerClient, containerID); err != nil && !errors.Is(err, ErrContainerNotRunning) {
+		log.Printf("Removing the failed container %.12s: %v\n", containerID, err)
+	}
+}
+
+// removeStaleContainer removes the container holding name when it is a tlex container not running,
+// e.g. one created by an interrupted launch. It tells whether name was freed.
+func removeStaleContainer(ctx context.Context, dockerClient ContainerEngine, name string) bool {
+
+	inspect, err := dockerClient.ContainerInspect(ctx, name)
+	if err != nil || inspect.ContainerJSONBase == nil || inspect.State == nil || inspect.Config == nil {
+		return false
+	}
+	if inspect.State.Running || inspect.Config.Labels[LabelRunID] == "" {
+		return false
 	}
 
-	return containerBody, err
+	log.Printf("Removing the stale container %s from launch %s.\n", name, inspect.Config.Labels[LabelRunID])
+	if err = removeContainer(ctx, dockerClient, inspect.ID); err != nil && !errors.Is(err, ErrContainerNotRunning) {
+		log.Printf("Removing the stale container %s: %v\n", name, err)
+		return false
+	}
+
+	return true
 }
 
 // setContainerLive starts a created container in active live state.
-func setContainerLive(dockerClient *client.Client, containerID string) (string, error) {
+func setContainerLive(ctx context.Context, dockerClient ContainerEngine, containerID string) (string, error) {
 
-	err := dockerClient.ContainerStart(context.Background(), containerID, types.ContainerStartOptions{})
-	return containerID, err
+	err := dockerClient.ContainerStart(ctx, containerID, types.ContainerStartOptions{})
+	return containerID, classify(err)
 
 }
 
 // CreateNewContainer
-// 1. creates a new container for the given dockeImageName and
-// 2. starts it into an active live state:
+// 1. creates a new container named name for the given dockeImageName, in place of a stale container holding name, and
+// 2. starts it into an active live state, or removes it:
 // at the container httpServerContainerPort value,
-// and at the host httpServerHostPort value.
-// Returns the new container ID, error.
-func setNewContainerLive(dockerClient *client.Client, imageName string, httpServerContainerPort int, httpServerHostPort int) (string, error) {
+// and at the host httpServerHostPort value, or at the host port chosen by Docker when 0, within limits.
+// Both requests are bounded by launchTimeout.
+// Returns the new container ID, its host port, a *ContainerError.
+func setNewContainerLive(ctx context.Context, dockerClient ContainerEngine, runID string, imageName string, limits *ContainerLimits, name string, httpServerContainerPort int, httpServerHostPort int, launchTimeout time.Duration) (string, int, error) {
+
+	ctx, cancel := WithTimeout(ctx, launchTimeout)
+	defer cancel()
 
-	cont, err := createContainer(dockerClient, imageName, httpServerContainerPort, httpServerHostPort)
-	containerID, err := setContainerLive(dockerClient, cont.ID)
+	cont, err := createContainer(ctx, dockerClient, runID, imageName, limits, name, httpServerContainerPort, httpServerHostPort)
+	if errors.Is(err, ErrNameConflict) && removeStaleContainer(ctx, dockerClient, name) {
+		cont, err = createContainer(ctx, dockerClient, runID, imageName, limits, name, httpServerContainerPort, httpServerHostPort)
+	}
+	if err != nil {
+		return "", 0, &ContainerError{Op: "create", HostPort: httpServerHostPort, Err: err}
+	}
+	containerID, err := setContainerLive(ctx, dockerClient, cont.ID)
 	if err != nil {
-		log.Printf("ContainerStart failed for the image: %s, host port: %d with error: %s\n", imageName, httpServerContainerPort, err)
-		return "", err
+		removeFailedContainer(dockerClient, containerID, launchTimeout)
+		return "", 0, &ContainerError{Op: "start", ContainerID: containerID, HostPort: httpServerHostPort, Err: err}
+	}
+	if httpServerHostPort == 0 {
+		httpServerHostPort, err = PublishedHostPort(ctx, dockerClient, containerID, httpServerContainerPort)
+		if err != nil {
+			removeFailedContainer(dockerClient, containerID, launchTimeout)
+			return "", 0, &ContainerError{Op: "inspect", ContainerID: containerID, Err: err}
+		}
 	}
 	log.Printf("Container %s with host port %d is live.\n", containerID, httpServerHostPort)
+	return containerID, httpServerHostPort, nil
+}
+
+// LaunchContainer creates and starts a container of runID at httpServerHostPort, like each of CreateContainers,
+// e.g. in place of a dead one, within limits. The launch is bounded by launchTimeout.
+// Returns the new container ID, a *ContainerError.
+func LaunchContainer(ctx context.Context, dockerClient ContainerEngine, runID string, imageName string, limits *ContainerLimits, httpServerContainerPort int, httpServerHostPort int, launchTimeout time.Duration) (string, error) {
+
+	containerID, _, err := setNewContainerLive(ctx, dockerClient, runID, imageName, limits, fmt.Sprintf("HttpServerAt_%d", httpServerHostPort), httpServerContainerPort, httpServerHostPort, launchTimeout)
 	return containerID, err
 }
 
 // CreateContainers requests live containers. It creates and starts them into an active live state for the given dockeImageName.
 // at the container httpServerContainerPort value.
-// and at the host httpServerHostPort value.
-func (owned OwnedContainers) CreateContainers(launcherGroup *errgroup.Group, requestedLiveContainers int, dockerClient *client.Client, dockerImageName string, startingListeningHostPort int, containerListeningPort int) {
+// and at the host ports handed out by hostPorts, within limits unless nil.
+// A container failing to start because its host port was taken meanwhile, e.g. by a published port the host probe
+// missed, is launched again at the next host port.
+// The launches are bounded, retried and tracked by launcher unless nil.
+// The containers are labeled as owned by runID. Each container launch is bounded by launchTimeout.
+// launcherGroup.Wait() returns the first launching *ContainerError.
+func (owned OwnedContainers) CreateContainers(ctx context.Context, launcherGroup *errgroup.Group, launcher *Launcher, requestedLiveContainers int, dockerClient ContainerEngine, runID string, dockerImageName string, limits *ContainerLimits, hostPorts *PortAllocator, containerListeningPort int, launchTimeout time.Duration) {
 
 	// Manage concurrent access to shared owned map
 	ownedMutex := &sync.Mutex{}
+	launcher.expect(requestedLiveContainers)
 
 	for i := 0; i < requestedLiveContainers; i++ {
 
@@ -365,30 +654,101 @@ func (owned OwnedContainers) CreateContainers(launcherGroup *errgroup.Group, req
 		// Concurrent launching of docker instances
 		launcherGroup.Go(func() error {
 
-			hostPort := startingListeningHostPort + portCounter
-			containerID, err := setNewContainerLive(dockerClient, dockerImageName, containerListeningPort, hostPort)
-			if err != nil {
-				log.Printf("ContainerCreate failed for the image: %s, host port: %d with error:%s\n", dockerImageName, hostPort, err)
-			} else {
-
-				ownedMutex.Lock()
-				owned[containerID] = hostPort
-				ownedMutex.Unlock()
+			var containerID string
+			var hostPort int
+			for attempt := 1; ; attempt++ {
+				release, err := launcher.acquire(ctx)
+				if err != nil {
+					launcher.done(0, 0, err)
+					return &ContainerError{Op: "create", Err: err}
+				}
+				launchStart := time.Now()
+				containerID, hostPort, err = launchAtFreeHostPort(ctx, dockerClient, runID, dockerImageName, limits, portCounter, attempt, hostPorts, containerListeningPort, launchTimeout)
+				release()
+				if err == nil {
+					launcher.done(hostPort, time.Since(launchStart), nil)
+					break
+				}
+				if !launcher.retry(ctx, attempt, err) {
+					launcher.done(hostPort, 0, err)
+					log.Printf("Launching failed for the image: %s: %v\n", dockerImageName, err)
+					return err
+				}
 			}
 
-			return err
+			ownedMutex.Lock()
+			owned[containerID] = hostPort
+			ownedMutex.Unlock()
+
+			return nil
 		})
 	}
 }
 
-// PersistOpenContainers saves the presumed populated owned containers map id-> ports into the filesystem.
-func (owned OwnedContainers) PersistOpenContainerIDs() {
+// launchAtFreeHostPort launches the slot-th container of runID at the next host port of hostPorts
+// until one is not taken. The host port of a failed launch is released for the retries.
+// A container at a host port chosen by Docker is named after its slot and launch attempt.
+// Returns the new container ID, its host port, a *ContainerError or an error matching ErrPortInUse
+// once the host ports are exhausted.
+func launchAtFreeHostPort(ctx context.Context, dockerClient ContainerEngine, runID string, imageName string, limits *ContainerLimits, slot int, attempt int, hostPorts *PortAllocator, containerListeningPort int, launchTimeout time.Duration) (string, int, error) {
+
+	for {
+		hostPort, err := hostPorts.Allocate()
+		if err != nil {
+			return "", 0, err
+		}
+		name := fmt.Sprintf("HttpServerAt_%d", hostPort)
+		if hostPort == 0 {
+			name = fmt.Sprintf("HttpServerAt_%s_%d", runID, slot)
+			if attempt > 1 {
+				name = fmt.Sprintf("%s_%d", name, attempt)
+			}
+		}
+
+		containerID, liveHostPort, err := setNewContainerLive(ctx, dockerClient, runID, imageName, limits, name, containerListeningPort, hostPort, launchTimeout)
+		var containerErr *ContainerError
+		if hostPort > 0 && errors.As(err, &containerErr) && containerErr.Op == "start" && errors.Is(err, ErrPortInUse) {
+			log.Printf("Host port %d is taken, launching at the next one: %v\n", hostPort, err)
+			continue
+		}
+		if err != nil {
+			hostPorts.Release(hostPort)
+		}
+
+		return containerID, liveHostPort, err
+	}
+}
 
-	mapToSave := map[string]int(owned)
+// PersistOwnedContainers saves the presumed populated owned containers of the runID launch
+// with their name, image digest, creation time and restarts by host port into the state file.
+func (owned OwnedContainers) PersistOwnedContainers(ctx context.Context, dockerClient ContainerEngine, runID string, restarts map[int]int) error {
 
-	err := mapsi2disk.SaveContainerPorts2Disk(mapsi2disk.GobFilename, &mapToSave)
+	containers, err := Owner{RunID: runID}.ListContainers(ctx, dockerClient)
 	if err != nil {
-		log.Printf("SaveContainerPorts2Disk() error = %v\n", err)
+		return fmt.Errorf("unable to persist the owned containers: %w", err)
+	}
+	listed := make(map[string]types.Container, len(containers))
+	for _, container := range containers {
+		listed[container.ID] = container
 	}
 
-}
\ No newline at end of file
+	launchState := &state.File{RunID: runID, Containers: []state.Container{}}
+	for containerID, hostPort := range owned {
+		stateContainer := state.Container{ID: containerID, HostPort: hostPort, RunID: runID, Restarts: restarts[hostPort]}
+		if container, ok := listed[containerID]; ok {
+			stateContainer.Name = containerName(container)
+			stateContainer.ImageDigest = container.ImageID
+			stateContainer.CreatedAt = time.Unix(container.Created, 0).UTC()
+		}
+		launchState.Containers = append(launchState.Containers, stateContainer)
+	}
+	sort.Slice(launchState.Containers, func(i, j int) bool {
+		return launchState.Containers[i].HostPort < launchState.Containers[j].HostPort
+	})
+
+	if err = state.Save(state.Filename, launchState); err != nil {
+		return fmt.Errorf("unable to persist the owned containers: %w", err)
+	}
+
+	return nil
+}
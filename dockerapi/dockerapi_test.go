@@ -0,0 +1,405 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"tlex/internal/fakeengine"
+	"tlex/state"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ ContainerEngine = (*fakeengine.Engine)(nil)
+
+func Test_CreateContainers_Are_Owned_And_Live(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 3, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+	if len(owned) != 3 {
+		t.Fatalf("CreateContainers() owns %d containers, want 3", len(owned))
+	}
+
+	ports := make(map[int]bool)
+	for _, hostPort := range owned {
+		ports[hostPort] = true
+	}
+	for hostPort := 8770; hostPort < 8773; hostPort++ {
+		if !ports[hostPort] {
+			t.Errorf("No owned container at host port %d", hostPort)
+		}
+	}
+
+	if err := owned.AssertOwnedContainersAreLive(context.Background(), 3, fake); err != nil {
+		t.Errorf("AssertOwnedContainersAreLive() error = %v", err)
+	}
+	if err := AssertRequestedContainersAreLive(context.Background(), 3, fake); err != nil {
+		t.Errorf("AssertRequestedContainersAreLive() error = %v", err)
+	}
+	if err := AssertRequestedContainersAreGone(context.Background(), fake); !errors.Is(err, ErrContainersStillLive) {
+		t.Errorf("AssertRequestedContainersAreGone() error = %v, want ErrContainersStillLive", err)
+	}
+
+	if err := (Owner{RunID: "run"}).CleanLeftOverContainers(context.Background(), fake, 0); err != nil {
+		t.Errorf("CleanLeftOverContainers() error = %v", err)
+	}
+	if err := AssertRequestedContainersAreGone(context.Background(), fake); err != nil {
+		t.Errorf("AssertRequestedContainersAreGone() error = %v", err)
+	}
+	if err := owned.AssertOwnedContainersAreLive(context.Background(), 0, fake); !errors.Is(err, ErrContainerNotRunning) {
+		t.Errorf("AssertOwnedContainersAreLive() of stopped containers error = %v, want ErrContainerNotRunning", err)
+	}
+}
+
+func Test_Errors_Are_Classified(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 1, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	_, _, err := setNewContainerLive(context.Background(), fake, "run", "echo:latest", nil, "HttpServerAt_8770", 8770, 8770, 0)
+	var containerErr *ContainerError
+	if !errors.As(err, &containerErr) || containerErr.HostPort != 8770 {
+		t.Errorf("setNewContainerLive() of a taken name error = %v, want a *ContainerError at port 8770", err)
+	}
+
+	otherName, err := fake.ContainerCreate(context.Background(), &container.Config{}, &container.HostConfig{PortBindings: nat.PortMap{"8770/tcp": {{HostPort: "8770"}}}}, nil, "other")
+	if err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if _, err = setContainerLive(context.Background(), fake, otherName.ID); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("setContainerLive() on a bound port error = %v, want ErrPortInUse", err)
+	}
+
+	if err = classify(fake.ContainerStop(context.Background(), "gone", nil)); !errors.Is(err, ErrContainerNotRunning) {
+		t.Errorf("classify() of a missing container error = %v, want ErrContainerNotRunning", err)
+	}
+}
+
+func Test_CreateContainers_Honor_LaunchTimeout(t *testing.T) {
+
+	fake := fakeengine.New()
+	fake.Delay = time.Second
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 10*time.Millisecond)
+	err := launcherGroup.Wait()
+	var containerErr *ContainerError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &containerErr) {
+		t.Errorf("CreateContainers() slower than the launch timeout error = %v, want a *ContainerError matching context.DeadlineExceeded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = AssertRequestedContainersAreGone(ctx, fake); !errors.Is(err, context.Canceled) {
+		t.Errorf("AssertRequestedContainersAreGone() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+func Test_GetContainersStreamReaders(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	logReaders, err := owned.GetContainersLogReaders(context.Background(), fake)
+	if err != nil {
+		t.Fatalf("GetContainersLogReaders() error = %v", err)
+	}
+	statsReaders, err := owned.GetContainersStatsReaders(context.Background(), fake)
+	if err != nil {
+		t.Fatalf("GetContainersStatsReaders() error = %v", err)
+	}
+	if len(logReaders) != 2 || len(statsReaders) != 2 {
+		t.Errorf("Got %d log and %d stats readers, want 2 of each", len(logReaders), len(statsReaders))
+	}
+
+	// Stopping the containers ends their streams.
+	Owner{RunID: "run"}.CleanLeftOverContainers(context.Background(), fake, 0)
+	for _, reader := range append(logReaders, statsReaders...) {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := reader.ReaderStream.Read(buf); err != nil {
+				break
+			}
+		}
+		reader.ReaderStream.Close()
+	}
+}
+
+func Test_Owner_Selects_Labeled_Containers(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	runs := []struct {
+		runID     string
+		startPort int
+	}{{"first", 8770}, {"second", 8780}}
+	for _, run := range runs {
+		var launcherGroup errgroup.Group
+		owned := make(OwnedContainers)
+		owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, run.runID, "echo:latest", nil, hostPorts(run.startPort), 8770, 0)
+		if err := launcherGroup.Wait(); err != nil {
+			t.Fatalf("CreateContainers() error = %v", err)
+		}
+	}
+	foreign, err := fake.ContainerCreate(context.Background(), &container.Config{}, &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8790"}}}}, nil, "foreign")
+	if err == nil {
+		err = fake.ContainerStart(context.Background(), foreign.ID, types.ContainerStartOptions{})
+	}
+	if err != nil {
+		t.Fatalf("Starting the foreign container error = %v", err)
+	}
+
+	containers, err := (Owner{RunID: "first"}).ListContainers(context.Background(), fake)
+	if err != nil || len(containers) != 2 {
+		t.Fatalf("ListContainers() of a run = %d containers, error = %v, want 2", len(containers), err)
+	}
+	for _, container := range containers {
+		if container.Labels[LabelRunID] != "first" || container.Labels[LabelImage] != "echo:latest" || container.Labels[LabelStartedAt] == "" {
+			t.Errorf("Container %s labels = %v", container.ID, container.Labels)
+		}
+	}
+	if containers, err = (Owner{}).ListContainers(context.Background(), fake); !errors.Is(err, ErrNoRunID) || len(containers) != 0 {
+		t.Errorf("ListContainers() without a run id = %d containers, error = %v, want ErrNoRunID", len(containers), err)
+	}
+	if containers, _ = (Owner{RunID: "second", FirstHostPort: 8781, LastHostPort: 8790}).ListContainers(context.Background(), fake); len(containers) != 1 || labeledHostPort(containers[0]) != 8781 {
+		t.Errorf("ListContainers() of host ports 8781-8790 = %v, want the container at 8781", containers)
+	}
+
+	if err = RemoveLiveContainersFromPreviousRun(context.Background(), fake, Owner{RunID: "second", FirstHostPort: 8780, LastHostPort: 8781}, 0); err != nil {
+		t.Errorf("RemoveLiveContainersFromPreviousRun() error = %v", err)
+	}
+	if err = AssertRequestedContainersAreLive(context.Background(), 3, fake); err != nil {
+		t.Errorf("RemoveLiveContainersFromPreviousRun() stepped on other containers: %v", err)
+	}
+	if containers, _ = (Owner{RunID: "second"}).ListContainers(context.Background(), fake); len(containers) != 0 {
+		t.Errorf("RemoveLiveContainersFromPreviousRun() left %d containers of the second run", len(containers))
+	}
+}
+
+func Test_PersistOwnedContainers_Then_Load(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	if err := owned.PersistOwnedContainers(context.Background(), fake, "run", map[int]int{8771: 2}); err != nil {
+		t.Fatalf("PersistOwnedContainers() error = %v", err)
+	}
+	defer state.Delete(state.Filename)
+
+	launchState, err := state.Load(state.Filename)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	if launchState.RunID != "run" || len(launchState.Containers) != 2 {
+		t.Fatalf("Persisted state = %+v, want 2 containers of run", launchState)
+	}
+	for i, cont := range launchState.Containers {
+		if cont.HostPort != 8770+i || cont.Name != fmt.Sprintf("HttpServerAt_%d", cont.HostPort) || cont.ImageDigest == "" || cont.CreatedAt.IsZero() || cont.RunID != "run" || cont.Restarts != 2*i {
+			t.Errorf("Persisted container = %+v", cont)
+		}
+	}
+
+	loaded, err := LoadOwnedContainers()
+	if err != nil {
+		t.Fatalf("LoadOwnedContainers() error = %v", err)
+	}
+	for containerID, hostPort := range owned {
+		if loaded[containerID] != hostPort {
+			t.Errorf("LoadOwnedContainers() port of %s = %d, want %d", containerID, loaded[containerID], hostPort)
+		}
+	}
+}
+
+func Test_GetContainersLogReaders_Detect_TTY(t *testing.T) {
+
+	fake := fakeengine.New()
+	ctx := context.Background()
+
+	created, err := fake.ContainerCreate(ctx, &container.Config{Image: "echo:latest", Tty: true}, &container.HostConfig{
+		PortBindings: nat.PortMap{"8770/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8770"}}},
+	}, nil, "HttpServerAt_8770")
+	if err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if err = fake.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		t.Fatalf("ContainerStart() error = %v", err)
+	}
+
+	streams, err := OwnedContainers{created.ID: 8770}.GetContainersLogReaders(ctx, fake)
+	if err != nil {
+		t.Fatalf("GetContainersLogReaders() error = %v", err)
+	}
+	defer streams[0].ReaderStream.Close()
+	if !streams[0].TTY {
+		t.Fatalf("GetContainersLogReaders() TTY = false, want true")
+	}
+
+	if streams[0].ContainerName != "HttpServerAt_8770" {
+		t.Errorf("GetContainersLogReaders() ContainerName = %q, want HttpServerAt_8770", streams[0].ContainerName)
+	}
+
+	line, err := NewLogLineReader(streams[0].ReaderStream, true).ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine() error = %v", err)
+	}
+	if _, message, ok := SplitLogTimestamp(line.Text); !ok || message != "Log line 0 of HttpServerAt_8770" {
+		t.Errorf("ReadLine() = %q, want a timestamped Log line 0 of HttpServerAt_8770", line.Text)
+	}
+}
+
+func Test_OpenLogStream_Resumes_Since(t *testing.T) {
+
+	fake := fakeengine.New()
+	ctx := context.Background()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(ctx, &launcherGroup, nil, 1, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+	defer Owner{RunID: "run"}.CleanLeftOverContainers(ctx, fake, 0)
+
+	var containerID string
+	for containerID = range owned {
+	}
+	// readLine returns the nth line of the log stream since since.
+	readLine := func(since time.Time, n int) (time.Time, string) {
+		stream, err := OpenLogStream(ctx, fake, containerID, 8770, since)
+		if err != nil {
+			t.Fatalf("OpenLogStream() error = %v", err)
+		}
+		defer stream.ReaderStream.Close()
+		lineReader := NewLogLineReader(stream.ReaderStream, stream.TTY)
+		var line LogLine
+		for i := 0; i <= n; i++ {
+			if line, err = lineReader.ReadLine(); err != nil {
+				t.Fatalf("ReadLine() error = %v", err)
+			}
+		}
+		timestamp, message, _ := SplitLogTimestamp(line.Text)
+		return timestamp, message
+	}
+
+	first, message := readLine(time.Time{}, 0)
+	if message != "Log line 0 of HttpServerAt_8770" {
+		t.Fatalf("ReadLine() = %q, want Log line 0 of HttpServerAt_8770", message)
+	}
+	// The stream since the first line replays it and the following ones.
+	if _, message = readLine(first, 2); message != "Log line 2 of HttpServerAt_8770" {
+		t.Errorf("ReadLine() of the third line since the first = %q, want Log line 2 of HttpServerAt_8770", message)
+	}
+	if _, message = readLine(first.Add(time.Nanosecond), 2); message != "Log line 3 of HttpServerAt_8770" {
+		t.Errorf("ReadLine() of the third line after the first = %q, want Log line 3 of HttpServerAt_8770", message)
+	}
+}
+
+func Test_Failed_Start_Removes_The_Container(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	foreign, err := fake.ContainerCreate(context.Background(), &container.Config{}, &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8770"}}}}, nil, "foreign")
+	if err == nil {
+		err = fake.ContainerStart(context.Background(), foreign.ID, types.ContainerStartOptions{})
+	}
+	if err != nil {
+		t.Fatalf("Starting the foreign container error = %v", err)
+	}
+
+	if _, _, err = setNewContainerLive(context.Background(), fake, "run", "echo:latest", nil, "HttpServerAt_8770", 8770, 8770, 0); !errors.Is(err, ErrPortInUse) {
+		t.Fatalf("setNewContainerLive() on a bound port error = %v, want ErrPortInUse", err)
+	}
+	containers, err := fake.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil || len(containers) != 1 || containers[0].ID != foreign.ID {
+		t.Errorf("ContainerList() after a failed start = %v, error = %v, want the foreign container only", containers, err)
+	}
+}
+
+func Test_Stale_Containers_Are_Removed(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	// A stale container of an earlier launch holds the name of the 1st host port, left created.
+	if _, err := createContainer(context.Background(), fake, "earlier", "echo:latest", nil, "HttpServerAt_8770", 8770, 8770); err != nil {
+		t.Fatalf("createContainer() error = %v", err)
+	}
+	launchedID, _, err := setNewContainerLive(context.Background(), fake, "run", "echo:latest", nil, "HttpServerAt_8770", 8770, 8770, 0)
+	if err != nil {
+		t.Fatalf("setNewContainerLive() in place of a stale container error = %v", err)
+	}
+
+	// The stale containers within the host ports go. The running one, a stale one at a host port of another fleet
+	// and a foreign one are left alone.
+	for _, hostPort := range []int{8771, 8772, 8790} {
+		if _, err := createContainer(context.Background(), fake, "earlier", "echo:latest", nil, fmt.Sprintf("HttpServerAt_%d", hostPort), 8770, hostPort); err != nil {
+			t.Fatalf("createContainer() error = %v", err)
+		}
+	}
+	if _, err := createContainer(context.Background(), fake, "earlier", "echo:latest", nil, "HttpServerAt_9000", 8770, 9000); err != nil {
+		t.Fatalf("createContainer() error = %v", err)
+	}
+	if _, err := fake.ContainerCreate(context.Background(), &container.Config{}, &container.HostConfig{}, nil, "HttpServerAt_foreign"); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	// A stale container of another tlex instance's launch within the host ports is left alone too.
+	if _, err := createContainer(context.Background(), fake, "other", "echo:latest", nil, "HttpServerAt_8773", 8770, 8773); err != nil {
+		t.Fatalf("createContainer() error = %v", err)
+	}
+
+	if err = (Owner{}).RemoveStaleContainers(context.Background(), fake, 0); !errors.Is(err, ErrNoRunID) {
+		t.Errorf("RemoveStaleContainers() without a run id error = %v, want ErrNoRunID", err)
+	}
+	if err = (Owner{RunID: "earlier", FirstHostPort: 8770, LastHostPort: 8800}).RemoveStaleContainers(context.Background(), fake, 0); err != nil {
+		t.Fatalf("RemoveStaleContainers() error = %v", err)
+	}
+	containers, err := fake.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		t.Fatalf("ContainerList() error = %v", err)
+	}
+	left := make(map[string]bool)
+	for _, container := range containers {
+		left[containerName(container)] = true
+	}
+	for _, name := range []string{"HttpServerAt_8770", "HttpServerAt_8773", "HttpServerAt_9000", "HttpServerAt_foreign"} {
+		if !left[name] {
+			t.Errorf("RemoveStaleContainers() removed %s", name)
+		}
+	}
+	if len(containers) != 4 {
+		t.Errorf("RemoveStaleContainers() left %d containers, want 4", len(containers))
+	}
+	if inspect, err := fake.ContainerInspect(context.Background(), launchedID); err != nil || !inspect.State.Running {
+		t.Errorf("The launched container is not running, error = %v", err)
+	}
+}
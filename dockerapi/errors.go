@@ -0,0 +1,122 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Sentinel errors classifying the Docker remote api failures. Test for them with errors.Is.
+var (
+	// ErrDaemonUnreachable reports the Docker daemon cannot be connected to.
+	ErrDaemonUnreachable = errors.New("docker daemon unreachable")
+	// ErrImageNotFound reports the requested image does not exist locally.
+	ErrImageNotFound = errors.New("docker image not found")
+	// ErrPortInUse reports the requested host port is already bound.
+	ErrPortInUse = errors.New("host port in use")
+	// ErrContainerNotRunning reports an owned container that is missing or not in running state.
+	ErrContainerNotRunning = errors.New("container not running")
+	// ErrNotEnoughContainers reports fewer live containers than requested.
+	ErrNotEnoughContainers = errors.New("not enough live containers")
+	// ErrContainersStillLive reports containers left live after a teardown.
+	ErrContainersStillLive = errors.New("containers still live")
+	// ErrNoRunID reports an Owner without a run id, which selects no containers.
+	ErrNoRunID = errors.New("no run id selects the containers")
+	// ErrContainerUnhealthy reports a container whose http server fails its health checks.
+	ErrContainerUnhealthy = errors.New("container unhealthy")
+	// ErrDaemonBusy reports a daemon too busy to serve the request.
+	ErrDaemonBusy = errors.New("docker daemon busy")
+	// ErrNameConflict reports a container name still held by another container e.g. a stale one being removed.
+	ErrNameConflict = errors.New("container name in use")
+)
+
+// ContainerError records a failed operation on a container.
+type ContainerError struct {
+	// Op is the failed operation e.g. create, start, stop, logs, stats.
+	Op          string
+	ContainerID string
+	HostPort    int
+	Err         error
+}
+
+func (e *ContainerError) Error() string {
+
+	if e.ContainerID == "" {
+		return fmt.Sprintf("container %s at host port %d: %v", e.Op, e.HostPort, e.Err)
+	}
+
+	return fmt.Sprintf("container %s %.12s at host port %d: %v", e.Op, e.ContainerID, e.HostPort, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ContainerError) Unwrap() error {
+
+	return e.Err
+}
+
+// engineError pairs a sentinel kind with the daemon error it classifies so that
+// errors.Is matches the kind while the daemon message and error chain are kept.
+type engineError struct {
+	kind error
+	err  error
+}
+
+func (e *engineError) Error() string {
+
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *engineError) Is(target error) bool {
+
+	return target == e.kind
+}
+
+func (e *engineError) Unwrap() error {
+
+	return e.err
+}
+
+// classify wraps a Docker remote api error with its sentinel kind when recognized.
+// Timed out and cancelled requests match context.DeadlineExceeded and context.Canceled.
+func classify(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return err
+	case strings.Contains(msg, context.DeadlineExceeded.Error()):
+		return &engineError{context.DeadlineExceeded, err}
+	case strings.Contains(msg, context.Canceled.Error()):
+		return &engineError{context.Canceled, err}
+	case client.IsErrConnectionFailed(err):
+		return &engineError{ErrDaemonUnreachable, err}
+	case strings.Contains(msg, "port is already allocated") || strings.Contains(msg, "address already in use"):
+		return &engineError{ErrPortInUse, err}
+	case strings.Contains(msg, "No such image") || strings.Contains(msg, "pull access denied"):
+		return &engineError{ErrImageNotFound, err}
+	case strings.Contains(msg, "is not running") || strings.Contains(msg, "No such container"):
+		return &engineError{ErrContainerNotRunning, err}
+	case strings.Contains(msg, "is already in use by container"):
+		return &engineError{ErrNameConflict, err}
+	case strings.Contains(msg, "Too Many Requests") || strings.Contains(msg, "Service Unavailable") ||
+		strings.Contains(msg, "i/o timeout") || strings.Contains(msg, "connection reset by peer"):
+		return &engineError{ErrDaemonBusy, err}
+	}
+
+	return err
+}
+
+// IsTransient tells whether a failed request may succeed when retried: a timed out request,
+// a busy daemon or a container name held by a stale container.
+func IsTransient(err error) bool {
+
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrDaemonBusy) || errors.Is(err, ErrNameConflict)
+}
@@ -0,0 +1,112 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+// Container lifecycle event actions subscribed to by SubscribeEvents.
+const (
+	EventStart        = "start"
+	EventDie          = "die"
+	EventOOM          = "oom"
+	EventKill         = "kill"
+	EventHealthStatus = "health_status"
+)
+
+// EventActions are the container lifecycle event actions subscribed to by SubscribeEvents.
+var EventActions = []string{EventStart, EventDie, EventOOM, EventKill, EventHealthStatus}
+
+// ContainerEvent is a lifecycle event of an owned container.
+type ContainerEvent struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	ContainerID   string    `json:"containerId"`
+	ContainerName string    `json:"containerName"`
+	HostPort      int       `json:"hostPort"`
+	// ExitCode of a die event.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Signal of a kill event e.g. 9.
+	Signal string `json:"signal,omitempty"`
+	// Health of a health_status event e.g. healthy, unhealthy.
+	Health string `json:"health,omitempty"`
+}
+
+// newContainerEvent converts a daemon container event message.
+// The daemon reports the health of a health_status event in its action e.g. "health_status: healthy".
+func newContainerEvent(message events.Message) ContainerEvent {
+
+	event := ContainerEvent{
+		Time:          time.Unix(0, message.TimeNano).UTC(),
+		Action:        message.Action,
+		ContainerID:   message.Actor.ID,
+		ContainerName: message.Actor.Attributes["name"],
+		Signal:        message.Actor.Attributes["signal"],
+	}
+	if message.TimeNano == 0 {
+		event.Time = time.Unix(message.Time, 0).UTC()
+	}
+	if hostPort, err := strconv.Atoi(message.Actor.Attributes[LabelHostPort]); err == nil {
+		event.HostPort = hostPort
+	}
+	if exitCode, err := strconv.Atoi(message.Actor.Attributes["exitCode"]); err == nil {
+		event.ExitCode = &exitCode
+	}
+	if strings.HasPrefix(message.Action, EventHealthStatus+":") {
+		event.Action = EventHealthStatus
+		event.Health = strings.TrimSpace(strings.TrimPrefix(message.Action, EventHealthStatus+":"))
+	}
+
+	return event
+}
+
+// SubscribeEvents streams the lifecycle events of the owner's containers, see EventActions, from since on,
+// or from now on when since is zero.
+// The events end when ctx is done or with the subscription error sent to the error channel.
+func (owner Owner) SubscribeEvents(ctx context.Context, dockerClient ContainerEngine, since time.Time) (<-chan ContainerEvent, <-chan error) {
+
+	options := types.EventsOptions{Filters: owner.filters()}
+	options.Filters.Add("type", events.ContainerEventType)
+	for _, action := range EventActions {
+		options.Filters.Add("event", action)
+	}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+
+	messages, errs := dockerClient.Events(ctx, options)
+	containerEvents := make(chan ContainerEvent)
+	subscriptionErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				subscriptionErr <- ctx.Err()
+				return
+			case err := <-errs:
+				subscriptionErr <- classify(err)
+				return
+			case message := <-messages:
+				event := newContainerEvent(message)
+				if !owner.selects(event.HostPort) {
+					continue
+				}
+				select {
+				case containerEvents <- event:
+				case <-ctx.Done():
+					subscriptionErr <- ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+
+	return containerEvents, subscriptionErr
+}
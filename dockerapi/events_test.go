@@ -0,0 +1,98 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"tlex/internal/fakeengine"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// nextEvent returns the next subscribed event or fails the test after a second.
+func nextEvent(t *testing.T, containerEvents <-chan ContainerEvent) ContainerEvent {
+
+	t.Helper()
+	select {
+	case event := <-containerEvents:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("No event received")
+		return ContainerEvent{}
+	}
+}
+
+func Test_SubscribeEvents_Of_Owned_Containers(t *testing.T) {
+
+	fake := fakeengine.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	owner := Owner{RunID: "run", FirstHostPort: 8770, LastHostPort: 8770}
+	containerEvents, errs := owner.SubscribeEvents(ctx, fake, time.Time{})
+
+	// Neither another run's nor a container outside the host ports.
+	if _, err := LaunchContainer(ctx, fake, "other", "echo:latest", nil, 8770, 8769, 0); err != nil {
+		t.Fatalf("LaunchContainer() error = %v", err)
+	}
+	if _, err := LaunchContainer(ctx, fake, "run", "echo:latest", nil, 8770, 8771, 0); err != nil {
+		t.Fatalf("LaunchContainer() error = %v", err)
+	}
+	containerID, err := LaunchContainer(ctx, fake, "run", "echo:latest", nil, 8770, 8770, 0)
+	if err != nil {
+		t.Fatalf("LaunchContainer() error = %v", err)
+	}
+
+	event := nextEvent(t, containerEvents)
+	if event.Action != EventStart || event.ContainerID != containerID || event.ContainerName != "HttpServerAt_8770" || event.HostPort != 8770 || event.Time.IsZero() {
+		t.Errorf("Start event = %+v", event)
+	}
+
+	if err = fake.Crash(containerID, 137, true); err != nil {
+		t.Fatalf("Crash() error = %v", err)
+	}
+	if event = nextEvent(t, containerEvents); event.Action != EventOOM {
+		t.Errorf("Event = %+v, want oom", event)
+	}
+	event = nextEvent(t, containerEvents)
+	if event.Action != EventDie || event.ExitCode == nil || *event.ExitCode != 137 {
+		t.Errorf("Event = %+v, want die with exit code 137", event)
+	}
+
+	fake.DropEvents()
+	select {
+	case err = <-errs:
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Subscription error = %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The dropped subscription did not end")
+	}
+
+	// Subscribing again since the start replays the missed events.
+	containerEvents, _ = owner.SubscribeEvents(ctx, fake, event.Time.Add(-time.Nanosecond))
+	if event = nextEvent(t, containerEvents); event.Action != EventDie {
+		t.Errorf("Replayed event = %+v, want die", event)
+	}
+}
+
+func Test_ContainerEvent_Health_Status(t *testing.T) {
+
+	event := newContainerEvent(events.Message{
+		Type:     events.ContainerEventType,
+		Action:   "health_status: unhealthy",
+		Actor:    events.Actor{ID: "abc", Attributes: map[string]string{"name": "HttpServerAt_8771", LabelHostPort: "8771"}},
+		TimeNano: 1500000000,
+	})
+
+	if event.Action != EventHealthStatus || event.Health != "unhealthy" || event.HostPort != 8771 || event.ExitCode != nil {
+		t.Errorf("newContainerEvent() = %+v", event)
+	}
+	if want := time.Unix(1, 500000000).UTC(); !event.Time.Equal(want) {
+		t.Errorf("newContainerEvent() time = %v, want %v", event.Time, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Ownership labels set on every container created by tlex.
+const (
+	// LabelRunID identifies the tlex launch that created the container.
+	LabelRunID = "io.tlex.run-id"
+	// LabelHostPort is the host port mapped to the container http server.
+	LabelHostPort = "io.tlex.host-port"
+	// LabelImage is the image the container was created from.
+	LabelImage = "io.tlex.image"
+	// LabelStartedAt is the RFC 3339 UTC time the container was launched.
+	LabelStartedAt = "io.tlex.started-at"
+)
+
+// Owner selects the containers labeled by tlex for a single launch.
+// Several tlex instances share one daemon as long as they do not share run ids or host ports.
+type Owner struct {
+	// RunID selects the containers of a single launch. An owner without one selects none, see ErrNoRunID.
+	RunID string
+	// FirstHostPort and LastHostPort bound the selected host ports when LastHostPort > 0.
+	FirstHostPort int
+	LastHostPort  int
+}
+
+// NewRunID returns a unique id for labeling the containers of a new launch.
+func NewRunID() string {
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// containerLabels returns the ownership labels of a container launched by runID.
+func containerLabels(runID string, imageName string, hostPort int) map[string]string {
+
+	return map[string]string{
+		LabelRunID:     runID,
+		LabelHostPort:  strconv.Itoa(hostPort),
+		LabelImage:     imageName,
+		LabelStartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// filters returns the ContainerList label filter of the owner.
+func (owner Owner) filters() filters.Args {
+
+	return filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", LabelRunID, owner.RunID)))
+}
+
+// labeledHostPort returns the host port label of a tlex container or 0.
+// The host port of a container labeled before Docker chose it, see NewEphemeralPortAllocator, is its published one.
+func labeledHostPort(container types.Container) int {
+
+	hostPort, err := strconv.Atoi(container.Labels[LabelHostPort])
+	if err != nil {
+		return 0
+	}
+	if hostPort == 0 {
+		for _, port := range container.Ports {
+			if port.PublicPort > 0 {
+				return int(port.PublicPort)
+			}
+		}
+	}
+
+	return hostPort
+}
+
+// ListContainers lists the owner's running containers.
+// It returns ErrNoRunID for an owner without a run id.
+func (owner Owner) ListContainers(ctx context.Context, dockerClient ContainerEngine) ([]types.Container, error) {
+
+	if owner.RunID == "" {
+		return nil, ErrNoRunID
+	}
+
+	containers, err := dockerClient.ContainerList(ctx, types.ContainerListOptions{Filters: owner.filters()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list containers: %w", classify(err))
+	}
+
+	if owner.LastHostPort == 0 {
+		return containers, nil
+	}
+
+	ownedList := []types.Container{}
+	for _, container := range containers {
+		if owner.selects(labeledHostPort(container)) {
+			ownedList = append(ownedList, container)
+		}
+	}
+
+	return ownedList, nil
+}
+
+// selects tells whether hostPort is within the owner's host ports.
+// Label filters match values exactly so the host port range is checked locally.
+func (owner Owner) selects(hostPort int) bool {
+
+	return owner.LastHostPort == 0 || hostPort >= owner.FirstHostPort && hostPort <= owner.LastHostPort
+}
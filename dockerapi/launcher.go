@@ -0,0 +1,269 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Launcher bounds the container launches of CreateContainers and tracks their progress and latency.
+// A container launch failing transiently, see IsTransient, or at all with RetryAll is retried after a jittered backoff.
+// A nil Launcher launches every container at once, once.
+type Launcher struct {
+	// Attempts bounds the launches of a container, 0 retries until the launch is cancelled.
+	Attempts int
+	// RetryAll retries every failure up to Attempts, not only the transient ones.
+	RetryAll bool
+	// Backoff is the wait before the first retry of a container, doubling up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// slots holds a token per create/start call in flight.
+	slots chan struct{}
+	// interval between launches when rate limited.
+	interval time.Duration
+
+	mutex     sync.Mutex
+	nextStart time.Time
+	started   time.Time
+	requested int
+	launched  int
+	failed    int
+	retried   int
+	inFlight  int
+	// peak is the most launches in flight at once.
+	peak int
+	// latencies of the live containers by host port.
+	latencies map[int]time.Duration
+}
+
+// LaunchProgress counts the launches of a Launcher.
+type LaunchProgress struct {
+	Launched int
+	Failed   int
+	Pending  int
+	// Retried counts the retried launches.
+	Retried int
+	Elapsed time.Duration
+	// ETA extrapolates the pending launches from the finished ones, 0 before the first one finishes.
+	ETA time.Duration
+}
+
+// LatencySummary sums up the create/start latency of the live containers.
+type LatencySummary struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+	// MaxHostPort is the host port of the slowest launch.
+	MaxHostPort int
+}
+
+// NewLauncher returns a launcher of at most maxInFlight concurrent launches and perSecond launches a second,
+// launching each container once. 0 lifts either limit.
+func NewLauncher(maxInFlight int, perSecond float64) *Launcher {
+
+	launcher := &Launcher{Attempts: 1, started: time.Now(), latencies: make(map[int]time.Duration)}
+	if maxInFlight > 0 {
+		launcher.slots = make(chan struct{}, maxInFlight)
+	}
+	if perSecond > 0 {
+		launcher.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+
+	return launcher
+}
+
+// expect adds n launches to the pending ones.
+func (launcher *Launcher) expect(n int) {
+
+	if launcher == nil {
+		return
+	}
+
+	launcher.mutex.Lock()
+	defer launcher.mutex.Unlock()
+
+	launcher.requested += n
+}
+
+// acquire waits for the launch rate, then for a free slot, and returns the release of the slot.
+// Waiting on the rate first keeps the slots for the launches that may start right away.
+func (launcher *Launcher) acquire(ctx context.Context) (func(), error) {
+
+	if launcher == nil {
+		return func() {}, nil
+	}
+
+	launcher.mutex.Lock()
+	now := time.Now()
+	start := launcher.nextStart
+	if start.Before(now) {
+		start = now
+	}
+	launcher.nextStart = start.Add(launcher.interval)
+	launcher.mutex.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if launcher.slots != nil {
+		select {
+		case launcher.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	launcher.mutex.Lock()
+	launcher.inFlight++
+	if launcher.inFlight > launcher.peak {
+		launcher.peak = launcher.inFlight
+	}
+	launcher.mutex.Unlock()
+
+	return func() {
+		launcher.mutex.Lock()
+		launcher.inFlight--
+		launcher.mutex.Unlock()
+		if launcher.slots != nil {
+			<-launcher.slots
+		}
+	}, nil
+}
+
+// retry tells whether the attempt-th launch of a container failing with err is retried,
+// after waiting the jittered backoff.
+func (launcher *Launcher) retry(ctx context.Context, attempt int, err error) bool {
+
+	if launcher == nil || !launcher.RetryAll && !IsTransient(err) || ctx.Err() != nil || launcher.Attempts > 0 && attempt >= launcher.Attempts {
+		return false
+	}
+
+	wait := launcher.Backoff
+	for i := 1; i < attempt && wait < launcher.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > launcher.MaxBackoff {
+		wait = launcher.MaxBackoff
+	}
+	// Equal jitter keeps at least half the backoff while spreading the retries of a busy fleet.
+	if wait > 1 {
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	log.Printf("Launch attempt %d failed: %v. Retrying in %v.\n", attempt, err, wait.Round(time.Millisecond))
+
+	launcher.mutex.Lock()
+	launcher.retried++
+	launcher.mutex.Unlock()
+
+	select {
+	case <-time.After(wait):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// done records the end of a launch of latency at hostPort.
+func (launcher *Launcher) done(hostPort int, latency time.Duration, err error) {
+
+	if launcher == nil {
+		return
+	}
+
+	launcher.mutex.Lock()
+	defer launcher.mutex.Unlock()
+
+	if err != nil {
+		launcher.failed++
+		return
+	}
+	launcher.launched++
+	launcher.latencies[hostPort] = latency
+}
+
+// Progress counts the launches so far.
+func (launcher *Launcher) Progress() LaunchProgress {
+
+	launcher.mutex.Lock()
+	defer launcher.mutex.Unlock()
+
+	progress := LaunchProgress{
+		Launched: launcher.launched,
+		Failed:   launcher.failed,
+		Pending:  launcher.requested - launcher.launched - launcher.failed,
+		Retried:  launcher.retried,
+		Elapsed:  time.Since(launcher.started),
+	}
+	if finished := progress.Launched + progress.Failed; finished > 0 {
+		progress.ETA = progress.Elapsed / time.Duration(finished) * time.Duration(progress.Pending)
+	}
+
+	return progress
+}
+
+// String renders the progress as a single line e.g. "launched 40/100, failed 1, pending 59, ETA 12s".
+func (progress LaunchProgress) String() string {
+
+	eta := "unknown"
+	if progress.ETA > 0 || progress.Pending == 0 {
+		eta = progress.ETA.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("launched %d/%d, failed %d, pending %d, ETA %s",
+		progress.Launched, progress.Launched+progress.Failed+progress.Pending, progress.Failed, progress.Pending, eta)
+}
+
+// Latencies sums up the latency of the live containers.
+func (launcher *Launcher) Latencies() LatencySummary {
+
+	launcher.mutex.Lock()
+	defer launcher.mutex.Unlock()
+
+	summary := LatencySummary{Count: len(launcher.latencies)}
+	if summary.Count == 0 {
+		return summary
+	}
+
+	latencies := make([]time.Duration, 0, summary.Count)
+	var total time.Duration
+	for hostPort, latency := range launcher.latencies {
+		latencies = append(latencies, latency)
+		total += latency
+		if latency > summary.Max || latency == summary.Max && hostPort < summary.MaxHostPort {
+			summary.Max = latency
+			summary.MaxHostPort = hostPort
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	summary.Min = latencies[0]
+	summary.Mean = total / time.Duration(summary.Count)
+	summary.P50 = latencies[(summary.Count-1)*50/100]
+	summary.P95 = latencies[(summary.Count-1)*95/100]
+
+	return summary
+}
+
+// String renders the summary as a single line e.g. "min 1.2s, mean 2.3s, p50 2s, p95 4.1s, max 4.5s at port 8790".
+func (summary LatencySummary) String() string {
+
+	if summary.Count == 0 {
+		return "none"
+	}
+
+	round := func(latency time.Duration) time.Duration { return latency.Round(time.Millisecond) }
+
+	return fmt.Sprintf("min %v, mean %v, p50 %v, p95 %v, max %v at port %d",
+		round(summary.Min), round(summary.Mean), round(summary.P50), round(summary.P95), round(summary.Max), summary.MaxHostPort)
+}
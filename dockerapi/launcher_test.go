@@ -0,0 +1,174 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"tlex/internal/fakeengine"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func Test_Launcher_Bounds_The_Launches_In_Flight(t *testing.T) {
+
+	fake := fakeengine.New()
+	fake.Delay = 10 * time.Millisecond
+
+	launcher := NewLauncher(2, 0)
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, launcher, 6, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	if launcher.peak != 2 {
+		t.Errorf("Launcher peaked at %d launches in flight, want 2", launcher.peak)
+	}
+	progress := launcher.Progress()
+	if progress.Launched != 6 || progress.Failed != 0 || progress.Pending != 0 || progress.ETA != 0 {
+		t.Errorf("Progress() = %+v, want 6 launched", progress)
+	}
+	summary := launcher.Latencies()
+	if summary.Count != 6 || summary.Min < fake.Delay || summary.Min > summary.P50 || summary.P50 > summary.P95 || summary.P95 > summary.Max {
+		t.Errorf("Latencies() = %+v", summary)
+	}
+	if !strings.Contains(summary.String(), "at port 87") {
+		t.Errorf("LatencySummary.String() = %q, want the port of the slowest launch", summary.String())
+	}
+}
+
+func Test_Launcher_Limits_The_Launch_Rate(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	launcher := NewLauncher(0, 50)
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, launcher, 5, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	// 5 launches at 50 a second start 20ms apart.
+	if elapsed := launcher.Progress().Elapsed; elapsed < 80*time.Millisecond {
+		t.Errorf("5 launches at 50 a second took %v, want at least 80ms", elapsed)
+	}
+}
+
+func Test_Launcher_Frees_Its_Slots_While_Waiting_For_The_Rate(t *testing.T) {
+
+	launcher := NewLauncher(1, 1)
+	release, err := launcher.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	waited := make(chan error)
+	go func() {
+		_, err := launcher.acquire(ctx)
+		waited <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if held := len(launcher.slots); held != 0 {
+		t.Errorf("Launcher holds %d slots while waiting for the rate, want 0", held)
+	}
+	if err = <-waited; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() error = %v, want the deadline exceeded", err)
+	}
+}
+
+func Test_Launch_Progress_Counts_Failures(t *testing.T) {
+
+	launcher := NewLauncher(1, 0)
+	launcher.expect(4)
+	launcher.started = time.Now().Add(-time.Second)
+	launcher.done(8770, time.Millisecond, nil)
+	launcher.done(0, 0, context.DeadlineExceeded)
+
+	progress := launcher.Progress()
+	if progress.Launched != 1 || progress.Failed != 1 || progress.Pending != 2 {
+		t.Errorf("Progress() = %+v, want 1 launched, 1 failed, 2 pending", progress)
+	}
+	if progress.ETA < time.Second {
+		t.Errorf("Progress().ETA = %v, want about 1s for 2 pending after 2 finished in 1s", progress.ETA)
+	}
+	if line := progress.String(); !strings.HasPrefix(line, "launched 1/4, failed 1, pending 2, ETA 1s") {
+		t.Errorf("LaunchProgress.String() = %q", line)
+	}
+	if line := NewLauncher(0, 0).Progress().String(); line != "launched 0/0, failed 0, pending 0, ETA 0s" {
+		t.Errorf("LaunchProgress.String() of no launch = %q", line)
+	}
+}
+
+func Test_Launcher_Retries_Transient_Failures(t *testing.T) {
+
+	busy := errors.New("Error response from daemon: Too Many Requests")
+	tests := []struct {
+		name         string
+		attempts     int
+		retryAll     bool
+		failures     []error
+		wantErr      error
+		wantRetried  int
+		wantLeftOver int
+	}{
+		{name: "transient", attempts: 3, failures: []error{busy, errors.New(`Conflict. The container name "/HttpServerAt_8770" is already in use by container "0123"`)}, wantRetried: 2},
+		{name: "exhausted", attempts: 2, failures: []error{busy, busy, busy}, wantErr: ErrDaemonBusy, wantRetried: 1, wantLeftOver: 1},
+		{name: "permanent", attempts: 3, failures: []error{errors.New("No such image: echo:latest")}, wantErr: ErrImageNotFound},
+		{name: "until launched", attempts: 0, failures: []error{busy, busy, busy, busy}, wantRetried: 4},
+		{name: "permanent retried", attempts: 2, retryAll: true, failures: []error{errors.New("No such image: echo:latest")}, wantRetried: 1},
+	}
+	for _, tt := range tests {
+		fake := fakeengine.New()
+		fake.CreateFailures = tt.failures
+
+		launcher := NewLauncher(0, 0)
+		launcher.Attempts = tt.attempts
+		launcher.RetryAll = tt.retryAll
+		launcher.Backoff = time.Millisecond
+		launcher.MaxBackoff = 2 * time.Millisecond
+		var launcherGroup errgroup.Group
+		owned := make(OwnedContainers)
+		owned.CreateContainers(context.Background(), &launcherGroup, launcher, 1, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+		err := launcherGroup.Wait()
+
+		if tt.wantErr == nil && (err != nil || len(owned) != 1) || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: CreateContainers() error = %v, owns %d containers, want error %v", tt.name, err, len(owned), tt.wantErr)
+		}
+		if progress := launcher.Progress(); progress.Retried != tt.wantRetried {
+			t.Errorf("%s: Progress().Retried = %d, want %d", tt.name, progress.Retried, tt.wantRetried)
+		}
+		if len(fake.CreateFailures) != tt.wantLeftOver {
+			t.Errorf("%s: %d create failures left, want %d", tt.name, len(fake.CreateFailures), tt.wantLeftOver)
+		}
+	}
+}
+
+func Test_Transient_Errors(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{classify(errors.New("Error response from daemon: Service Unavailable")), true},
+		{classify(errors.New(`Conflict. The container name "/x" is already in use by container "0123"`)), true},
+		{&ContainerError{Op: "create", Err: classify(context.DeadlineExceeded)}, true},
+		{classify(context.Canceled), false},
+		{classify(errors.New("No such image: echo:latest")), false},
+		{classify(errors.New("Bind for 0.0.0.0:8770 failed: port is already allocated")), false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
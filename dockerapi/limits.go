@@ -0,0 +1,56 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+)
+
+// ContainerLimits are the resource limits and the daemon restart policy of the launched containers.
+// 0 leaves a limit to the daemon default.
+type ContainerLimits struct {
+	NanoCPUs int64
+	// Memory and MemorySwap in bytes, MemorySwap -1 for unlimited swap.
+	Memory     int64
+	MemorySwap int64
+	PidsLimit  int64
+	Ulimits    []*units.Ulimit
+	// RestartPolicy of the daemon e.g. on-failure. Empty or no leaves the dead containers to tlex.
+	RestartPolicy     string
+	RestartMaxRetries int
+}
+
+// restarted tells whether the daemon restarts the dead containers.
+func (limits *ContainerLimits) restarted() bool {
+
+	return limits != nil && limits.RestartPolicy != "" && limits.RestartPolicy != "no"
+}
+
+// hostConfig returns the HostConfig of a container publishing portBindings within the limits.
+// A container is auto removed once dead unless the daemon restarts it, as the daemon refuses both.
+// A nil ContainerLimits sets no limit.
+func (limits *ContainerLimits) hostConfig(portBindings nat.PortMap) *container.HostConfig {
+
+	hostConfig := &container.HostConfig{
+		PortBindings: portBindings,
+		AutoRemove:   !limits.restarted(),
+	}
+	if limits == nil {
+		return hostConfig
+	}
+
+	hostConfig.NanoCPUs = limits.NanoCPUs
+	hostConfig.Memory = limits.Memory
+	hostConfig.MemorySwap = limits.MemorySwap
+	if limits.PidsLimit > 0 {
+		pidsLimit := limits.PidsLimit
+		hostConfig.PidsLimit = &pidsLimit
+	}
+	hostConfig.Ulimits = limits.Ulimits
+	if limits.restarted() {
+		hostConfig.RestartPolicy = container.RestartPolicy{Name: limits.RestartPolicy, MaximumRetryCount: limits.RestartMaxRetries}
+	}
+
+	return hostConfig
+}
@@ -0,0 +1,78 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"testing"
+
+	"tlex/internal/fakeengine"
+
+	"github.com/docker/docker/api/types"
+	units "github.com/docker/go-units"
+	"golang.org/x/sync/errgroup"
+)
+
+func Test_Containers_Are_Created_Within_The_Limits(t *testing.T) {
+
+	limits := &ContainerLimits{
+		NanoCPUs:          1500000000,
+		Memory:            256 * 1024 * 1024,
+		MemorySwap:        -1,
+		PidsLimit:         100,
+		Ulimits:           []*units.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}},
+		RestartPolicy:     "on-failure",
+		RestartMaxRetries: 3,
+	}
+	tests := []struct {
+		name           string
+		limits         *ContainerLimits
+		wantAutoRemove bool
+	}{
+		{"unlimited", nil, true},
+		{"limited", limits, false},
+		{"limited without a restart policy", &ContainerLimits{Memory: limits.Memory, RestartPolicy: "no"}, true},
+	}
+	for _, tt := range tests {
+		fake := fakeengine.New()
+		owned := make(OwnedContainers)
+		var launcherGroup errgroup.Group
+		owned.CreateContainers(context.Background(), &launcherGroup, nil, 1, fake, "run", "echo:latest", tt.limits, hostPorts(8770), 8770, 0)
+		if err := launcherGroup.Wait(); err != nil {
+			t.Fatalf("%s: CreateContainers() error = %v", tt.name, err)
+		}
+
+		for containerID := range owned {
+			inspect, err := fake.ContainerInspect(context.Background(), containerID)
+			if err != nil {
+				t.Fatalf("%s: ContainerInspect() error = %v", tt.name, err)
+			}
+			hostConfig := inspect.HostConfig
+			if hostConfig.AutoRemove != tt.wantAutoRemove {
+				t.Errorf("%s: AutoRemove = %v, want %v", tt.name, hostConfig.AutoRemove, tt.wantAutoRemove)
+			}
+			if tt.limits == nil {
+				continue
+			}
+			if hostConfig.Memory != tt.limits.Memory || hostConfig.NanoCPUs != tt.limits.NanoCPUs || hostConfig.MemorySwap != tt.limits.MemorySwap {
+				t.Errorf("%s: HostConfig resources = %+v, want %+v", tt.name, hostConfig.Resources, tt.limits)
+			}
+			if tt.limits.PidsLimit > 0 && (hostConfig.PidsLimit == nil || *hostConfig.PidsLimit != tt.limits.PidsLimit) {
+				t.Errorf("%s: PidsLimit = %v, want %d", tt.name, hostConfig.PidsLimit, tt.limits.PidsLimit)
+			}
+			if len(hostConfig.Ulimits) != len(tt.limits.Ulimits) {
+				t.Errorf("%s: Ulimits = %v, want %v", tt.name, hostConfig.Ulimits, tt.limits.Ulimits)
+			}
+			if tt.wantAutoRemove && hostConfig.RestartPolicy.Name != "" || !tt.wantAutoRemove && (hostConfig.RestartPolicy.Name != "on-failure" || hostConfig.RestartPolicy.MaximumRetryCount != 3) {
+				t.Errorf("%s: RestartPolicy = %+v", tt.name, hostConfig.RestartPolicy)
+			}
+		}
+
+		// Stopped containers are removed whether the daemon auto removes them or not.
+		if err := (Owner{RunID: "run"}).CleanLeftOverContainers(context.Background(), fake, 0); err != nil {
+			t.Errorf("%s: CleanLeftOverContainers() error = %v", tt.name, err)
+		}
+		if containers, _ := fake.ContainerList(context.Background(), types.ContainerListOptions{All: true}); len(containers) != 0 {
+			t.Errorf("%s: %d containers left after stopping", tt.name, len(containers))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// maxPort is the last TCP port.
+const maxPort = 65535
+
+// PortAllocator hands out the host ports of the containers: the ports from first up to last in order,
+// skipping the denied ones, those handed out before and those in use on the host.
+// An ephemeral allocator hands out 0 for Docker to choose the host port, see PublishedHostPort.
+type PortAllocator struct {
+	first     int
+	last      int
+	denied    map[int]bool
+	ephemeral bool
+
+	mutex sync.Mutex
+	next  int
+	// released ports are handed out again first.
+	released []int
+	// free probes whether a port is free on the host. Tests replace it.
+	free func(port int) bool
+}
+
+// NewPortAllocator returns an allocator of the host ports from first up to last, or up to 65535 when last is 0,
+// skipping the denied ones.
+func NewPortAllocator(first int, last int, denied map[int]bool) *PortAllocator {
+
+	if last == 0 {
+		last = maxPort
+	}
+
+	return &PortAllocator{first: first, last: last, denied: denied, next: first, free: hostPortFree}
+}
+
+// NewEphemeralPortAllocator returns an allocator letting Docker choose the host ports.
+func NewEphemeralPortAllocator() *PortAllocator {
+
+	return &PortAllocator{ephemeral: true}
+}
+
+// Allocate returns the next free host port, 0 for an ephemeral allocator.
+// It returns an error matching ErrPortInUse once the ports are exhausted.
+func (allocator *PortAllocator) Allocate() (int, error) {
+
+	if allocator.ephemeral {
+		return 0, nil
+	}
+
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+
+	for len(allocator.released) > 0 {
+		hostPort := allocator.released[0]
+		allocator.released = allocator.released[1:]
+		if allocator.free(hostPort) {
+			return hostPort, nil
+		}
+	}
+	for ; allocator.next <= allocator.last; allocator.next++ {
+		hostPort := allocator.next
+		if allocator.denied[hostPort] || !allocator.free(hostPort) {
+			continue
+		}
+		allocator.next++
+		return hostPort, nil
+	}
+
+	return 0, fmt.Errorf("%w: no free host port left in %d-%d", ErrPortInUse, allocator.first, allocator.last)
+}
+
+// Release hands hostPort out again, e.g. after its container failed to launch.
+func (allocator *PortAllocator) Release(hostPort int) {
+
+	if allocator.ephemeral || hostPort == 0 {
+		return
+	}
+
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+
+	allocator.released = append(allocator.released, hostPort)
+}
+
+// hostPortFree tells whether the TCP port can be listened to on all the host interfaces.
+// Docker publishes ports on all the interfaces by default.
+func hostPortFree(port int) bool {
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+
+	return true
+}
+
+// PublishedHostPort reads back the host port Docker bound to the tcp containerPort of a container.
+func PublishedHostPort(ctx context.Context, dockerClient ContainerEngine, containerID string, containerPort int) (int, error) {
+
+	inspect, err := dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, classify(err)
+	}
+
+	port, err := nat.NewPort("tcp", strconv.Itoa(containerPort))
+	if err != nil {
+		return 0, err
+	}
+	if inspect.NetworkSettings != nil {
+		for _, binding := range inspect.NetworkSettings.Ports[port] {
+			if hostPort, err := strconv.Atoi(binding.HostPort); err == nil && hostPort > 0 {
+				return hostPort, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no host port bound to %s", port)
+}
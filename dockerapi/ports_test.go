@@ -0,0 +1,128 @@
+// Package dockerapi is the facade to the Docker remote api.
+package dockerapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"tlex/internal/fakeengine"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/sync/errgroup"
+)
+
+// hostPorts hands out the host ports from first on without probing the host, the fake engine binding none.
+func hostPorts(first int) *PortAllocator {
+
+	allocator := NewPortAllocator(first, 0, nil)
+	allocator.free = func(int) bool { return true }
+
+	return allocator
+}
+
+func Test_PortAllocator_Skips_Denied_And_Used_Ports(t *testing.T) {
+
+	allocator := NewPortAllocator(8770, 8775, map[int]bool{8771: true, 8773: true})
+	allocator.free = func(port int) bool { return port != 8772 }
+
+	for _, want := range []int{8770, 8774, 8775} {
+		if hostPort, err := allocator.Allocate(); err != nil || hostPort != want {
+			t.Errorf("Allocate() = %d, error = %v, want %d", hostPort, err, want)
+		}
+	}
+	if hostPort, err := allocator.Allocate(); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("Allocate() past the last port = %d, error = %v, want ErrPortInUse", hostPort, err)
+	}
+
+	allocator.Release(8774)
+	if hostPort, err := allocator.Allocate(); err != nil || hostPort != 8774 {
+		t.Errorf("Allocate() after Release(8774) = %d, error = %v, want 8774", hostPort, err)
+	}
+}
+
+func Test_PortAllocator_Probes_The_Host(t *testing.T) {
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	usedPort := listener.Addr().(*net.TCPAddr).Port
+
+	if hostPortFree(usedPort) {
+		t.Errorf("hostPortFree(%d) of a listened port = true", usedPort)
+	}
+	if hostPort, err := NewPortAllocator(usedPort, usedPort, nil).Allocate(); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("Allocate() of a listened port = %d, error = %v, want ErrPortInUse", hostPort, err)
+	}
+}
+
+func Test_CreateContainers_Skip_Taken_Host_Ports(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	// The foreign container binds a host port the probe missed.
+	foreign, err := fake.ContainerCreate(context.Background(), &container.Config{}, &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8771"}}}}, nil, "foreign")
+	if err == nil {
+		err = fake.ContainerStart(context.Background(), foreign.ID, types.ContainerStartOptions{})
+	}
+	if err != nil {
+		t.Fatalf("Starting the foreign container error = %v", err)
+	}
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 3, fake, "run", "echo:latest", nil, hostPorts(8770), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	ports := make(map[int]bool)
+	for _, hostPort := range owned {
+		ports[hostPort] = true
+	}
+	for _, hostPort := range []int{8770, 8772, 8773} {
+		if !ports[hostPort] {
+			t.Errorf("No owned container at host port %d, owned = %v", hostPort, owned)
+		}
+	}
+
+	var exhausted errgroup.Group
+	owned.CreateContainers(context.Background(), &exhausted, nil, 1, fake, "run", "echo:latest", nil, NewPortAllocator(8780, 8780, map[int]bool{8780: true}), 8770, 0)
+	if err := exhausted.Wait(); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("CreateContainers() without a free host port error = %v, want ErrPortInUse", err)
+	}
+}
+
+func Test_CreateContainers_At_Ephemeral_Host_Ports(t *testing.T) {
+
+	fake := fakeengine.New()
+
+	var launcherGroup errgroup.Group
+	owned := make(OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, NewEphemeralPortAllocator(), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	containers, err := (Owner{RunID: "run"}).ListContainers(context.Background(), fake)
+	if err != nil || len(containers) != 2 {
+		t.Fatalf("ListContainers() = %d containers, error = %v, want 2", len(containers), err)
+	}
+	for _, container := range containers {
+		hostPort := owned[container.ID]
+		if hostPort == 0 || labeledHostPort(container) != hostPort {
+			t.Errorf("Container %s owned at host port %d, labeled at %d", containerName(container), hostPort, labeledHostPort(container))
+		}
+		if published, err := PublishedHostPort(context.Background(), fake, container.ID, 8770); err != nil || published != hostPort {
+			t.Errorf("PublishedHostPort() = %d, error = %v, want %d", published, err, hostPort)
+		}
+		if container.Labels[LabelHostPort] != "0" {
+			t.Errorf("Container %s host port label = %q, want 0", containerName(container), container.Labels[LabelHostPort])
+		}
+	}
+}
@@ -1,8 +1,12 @@
 @echo Windows Install Script
 go get github.com/docker/docker/client
 go get github.com/docker/go-connections/nat
+go get github.com/docker/go-units
 go get github.com/oklog/run
+go get gopkg.in/yaml.v2
+go get github.com/BurntSushi/toml
 del /s /q ..\github.com\docker\docker\vendor\github.com\docker\go-connections\nat
+del /s /q ..\github.com\docker\docker\vendor\github.com\docker\go-units
 go build ./...
 go build tlex
 @echo "Run ->"
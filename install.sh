@@ -2,8 +2,12 @@
 echo shell Install Script
 go get github.com/docker/docker/client
 go get github.com/docker/go-connections/nat
+go get github.com/docker/go-units
 go get github.com/oklog/run
+go get gopkg.in/yaml.v2
+go get github.com/BurntSushi/toml
 rm -rf ../github.com/docker/docker/vendor/github.com/docker/go-connections/nat
+rm -rf ../github.com/docker/docker/vendor/github.com/docker/go-units
 go build ./...
 go build tlex
 echo "Run ->"
@@ -0,0 +1,738 @@
+// Package fakeengine is an in-memory Docker remote api for unit testing tlex without a Docker daemon.
+package fakeengine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// The daemon's running container state and container event actions.
+const (
+	stateRunning = "running"
+	eventStart   = "start"
+	eventDie     = "die"
+	eventOOM     = "oom"
+	eventKill    = "kill"
+)
+
+// Engine is an in-memory dockerapi.ContainerEngine for unit testing the workflow without a Docker daemon.
+// Live containers serve a synthetic multiplexed log line and a stats snapshot every Tick
+// until they are stopped or the requesting context is done.
+// Requests fail with the context error once the requesting context is done.
+// Starting, stopping and crashing containers emit their daemon events.
+type Engine struct {
+	// Tick is the interval between synthetic log lines and stats snapshots.
+	Tick time.Duration
+	// Delay is the latency of the create, start, stop and build requests.
+	Delay time.Duration
+	// Echo serves the echo endpoint of the started containers at 127.0.0.1:<host port> like the echopathws
+	// image does: the requested path without its leading slash.
+	Echo bool
+	// CreateFailures fails the next create requests with their errors in order e.g. to test retries.
+	CreateFailures []error
+
+	mutex      sync.Mutex
+	containers map[string]*fakeContainer
+	// order keeps ContainerList output in creation order.
+	order  []string
+	nextID int
+	// nextEphemeralPort is the last host port chosen for a binding without one, unless Echo chose it.
+	nextEphemeralPort uint16
+	// eventLog keeps every emitted event for subscriptions since a past time.
+	eventLog    []events.Message
+	subscribers map[*fakeSubscriber]bool
+	// BuiltImages records the tags of every ImageBuild request.
+	BuiltImages []string
+}
+
+type fakeContainer struct {
+	types.Container
+	name       string
+	tty        bool
+	autoRemove bool
+	// hostConfig is the container's create request HostConfig.
+	hostConfig container.HostConfig
+	exitCode   int
+	oomKilled  bool
+	stopped    chan struct{}
+	// started is when the container last started, the time its log lines count from.
+	started time.Time
+	// servers serve the echo endpoint, see Echo.
+	servers []*http.Server
+	// dropped ends the streams opened so far with an error, see DropStreams.
+	dropped chan struct{}
+}
+
+// fakeSubscriber is an Events subscription.
+type fakeSubscriber struct {
+	options  types.EventsOptions
+	messages chan events.Message
+	errs     chan error
+}
+
+// New returns an empty Engine ticking every 10ms.
+func New() *Engine {
+
+	return &Engine{
+		Tick:              10 * time.Millisecond,
+		containers:        make(map[string]*fakeContainer),
+		nextEphemeralPort: 32767,
+		subscribers:       make(map[*fakeSubscriber]bool),
+	}
+}
+
+// ContainerList lists the running containers or all of them with options.All.
+// Only the "label" filter is supported.
+func (fake *Engine) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	containers := []types.Container{}
+	for _, containerID := range fake.order {
+		cont := fake.containers[containerID]
+		if (options.All || cont.State == stateRunning) && matchLabels(cont.Labels, options.Filters.Get("label")) {
+			containers = append(containers, cont.Container)
+		}
+	}
+
+	return containers, nil
+}
+
+// ContainerCreate registers a created container, failing on a duplicate name like the daemon does.
+func (fake *Engine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+
+	if err := fake.wait(ctx); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if len(fake.CreateFailures) > 0 {
+		err := fake.CreateFailures[0]
+		fake.CreateFailures = fake.CreateFailures[1:]
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	for _, cont := range fake.containers {
+		if containerName != "" && cont.name == containerName {
+			return container.ContainerCreateCreatedBody{}, fmt.Errorf("Conflict. The container name \"/%s\" is already in use by container %q", containerName, cont.ID)
+		}
+	}
+
+	fake.nextID++
+	containerID := fmt.Sprintf("%064x", fake.nextID)
+
+	var ports []types.Port
+	for containerPort, bindings := range hostConfig.PortBindings {
+		for _, binding := range bindings {
+			var publicPort uint16
+			fmt.Sscanf(binding.HostPort, "%d", &publicPort)
+			ports = append(ports, types.Port{IP: binding.HostIP, PrivatePort: uint16(containerPort.Int()), PublicPort: publicPort, Type: containerPort.Proto()})
+		}
+	}
+
+	fake.containers[containerID] = &fakeContainer{
+		Container: types.Container{
+			ID:      containerID,
+			Names:   []string{"/" + containerName},
+			Image:   config.Image,
+			ImageID: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(config.Image))),
+			Created: time.Now().Unix(),
+			Ports:   ports,
+			Labels:  config.Labels,
+			State:   "created",
+			Status:  "Created",
+		},
+		name:       containerName,
+		tty:        config.Tty,
+		autoRemove: hostConfig.AutoRemove,
+		hostConfig: *hostConfig,
+		stopped:    make(chan struct{}),
+		dropped:    make(chan struct{}),
+	}
+	fake.order = append(fake.order, containerID)
+
+	return container.ContainerCreateCreatedBody{ID: containerID}, nil
+}
+
+// ContainerStart sets a created container live.
+func (fake *Engine) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+
+	if err := fake.wait(ctx); err != nil {
+		return err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.containers[containerID]
+	if !ok {
+		return fmt.Errorf("No such container: %s", containerID)
+	}
+	for _, port := range cont.Ports {
+		if port.PublicPort == 0 {
+			continue
+		}
+		for _, other := range fake.containers {
+			if other.State != stateRunning {
+				continue
+			}
+			for _, otherPort := range other.Ports {
+				if otherPort.PublicPort == port.PublicPort {
+					return fmt.Errorf("driver failed programming external connectivity on endpoint %s: Bind for 0.0.0.0:%d failed: port is already allocated", cont.name, port.PublicPort)
+				}
+			}
+		}
+	}
+	// Copy the ports the listed containers share before choosing their host ports.
+	cont.Ports = append([]types.Port(nil), cont.Ports...)
+	if fake.Echo {
+		if err := cont.serveEcho(); err != nil {
+			return err
+		}
+	}
+	for i := range cont.Ports {
+		if cont.Ports[i].PublicPort == 0 {
+			fake.nextEphemeralPort++
+			cont.Ports[i].PublicPort = fake.nextEphemeralPort
+		}
+	}
+	cont.State = stateRunning
+	cont.Status = "Up Less than a second"
+	cont.started = time.Now()
+	fake.emit(cont, eventStart, nil)
+
+	return nil
+}
+
+// ContainerStop stops a container ending its streams. Auto removed containers disappear.
+func (fake *Engine) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+
+	if err := fake.wait(ctx); err != nil {
+		return err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.containers[containerID]
+	if !ok {
+		return fmt.Errorf("No such container: %s", containerID)
+	}
+	if cont.State == stateRunning {
+		fake.emit(cont, eventKill, map[string]string{"signal": "15"})
+		fake.exit(cont, 0)
+	}
+
+	return nil
+}
+
+// Crash ends a running container's process with exitCode, killed by the OOM killer if oomKilled,
+// as if it died on its own. Auto removed containers disappear.
+func (fake *Engine) Crash(containerID string, exitCode int, oomKilled bool) error {
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.containers[containerID]
+	if !ok {
+		return fmt.Errorf("No such container: %s", containerID)
+	}
+	if cont.State != stateRunning {
+		return fmt.Errorf("Container %s is not running", containerID)
+	}
+	if oomKilled {
+		cont.oomKilled = true
+		fake.emit(cont, eventOOM, nil)
+	}
+	fake.exit(cont, exitCode)
+
+	return nil
+}
+
+// exit ends a running container's streams and emits its die event. The caller holds the mutex.
+func (fake *Engine) exit(cont *fakeContainer, exitCode int) {
+
+	close(cont.stopped)
+	for _, server := range cont.servers {
+		server.Close()
+	}
+	cont.servers = nil
+	cont.State = "exited"
+	cont.Status = fmt.Sprintf("Exited (%d) Less than a second ago", exitCode)
+	cont.exitCode = exitCode
+	fake.emit(cont, eventDie, map[string]string{"exitCode": strconv.Itoa(exitCode)})
+
+	if cont.autoRemove {
+		fake.remove(cont.ID)
+	}
+}
+
+// serveEcho serves the echo endpoint at the container's host ports.
+// A binding without a host port gets the one chosen by the host.
+func (cont *fakeContainer) serveEcho() error {
+
+	for i, port := range cont.Ports {
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port.PublicPort))
+		if err != nil {
+			for _, server := range cont.servers {
+				server.Close()
+			}
+			cont.servers = nil
+			return fmt.Errorf("driver failed programming external connectivity on endpoint %s: Bind for 0.0.0.0:%d failed: port is already allocated", cont.name, port.PublicPort)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, strings.TrimPrefix(r.URL.Path, "/"))
+		})}
+		go server.Serve(listener)
+		cont.Ports[i].PublicPort = uint16(listener.Addr().(*net.TCPAddr).Port)
+		cont.servers = append(cont.servers, server)
+	}
+
+	return nil
+}
+
+// Events subscribes to the emitted events matching the "type", "event" and "label" filters of options,
+// replaying those since options.Since on, inclusive like the daemon. The subscription ends with the context error or DropEvents.
+func (fake *Engine) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+
+	subscriber := &fakeSubscriber{
+		options:  options,
+		messages: make(chan events.Message, 256),
+		errs:     make(chan error, 1),
+	}
+
+	fake.mutex.Lock()
+	if options.Since != "" {
+		since := parseSince(options.Since).UnixNano()
+		for _, message := range fake.eventLog {
+			if message.TimeNano >= since && subscriber.matches(message) {
+				select {
+				case subscriber.messages <- message:
+				default:
+				}
+			}
+		}
+	}
+	fake.subscribers[subscriber] = true
+	fake.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+		if fake.subscribers[subscriber] {
+			delete(fake.subscribers, subscriber)
+			subscriber.errs <- ctx.Err()
+		}
+	}()
+
+	return subscriber.messages, subscriber.errs
+}
+
+// DropEvents ends the events subscriptions with io.ErrUnexpectedEOF as a daemon restart would.
+func (fake *Engine) DropEvents() {
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for subscriber := range fake.subscribers {
+		delete(fake.subscribers, subscriber)
+		subscriber.errs <- io.ErrUnexpectedEOF
+	}
+}
+
+// emit records a container event and sends it to the matching subscribers, dropping it for the full ones.
+// The event carries the container's labels, name and image plus attributes. The caller holds the mutex.
+func (fake *Engine) emit(cont *fakeContainer, action string, attributes map[string]string) {
+
+	now := time.Now()
+	message := events.Message{
+		Status:   action,
+		ID:       cont.ID,
+		From:     cont.Image,
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: cont.ID, Attributes: map[string]string{"name": cont.name, "image": cont.Image}},
+		Scope:    "local",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	for key, value := range cont.Labels {
+		message.Actor.Attributes[key] = value
+	}
+	for key, value := range attributes {
+		message.Actor.Attributes[key] = value
+	}
+
+	fake.eventLog = append(fake.eventLog, message)
+	for subscriber := range fake.subscribers {
+		if subscriber.matches(message) {
+			select {
+			case subscriber.messages <- message:
+			default:
+			}
+		}
+	}
+}
+
+// matches reports whether message passes the subscription's type, event and label filters.
+// The event filter matches the action up to its colon e.g. health_status of "health_status: healthy".
+func (subscriber *fakeSubscriber) matches(message events.Message) bool {
+
+	filters := subscriber.options.Filters
+	if filters.Len() == 0 {
+		return true
+	}
+	if eventTypes := filters.Get("type"); len(eventTypes) > 0 && !contains(eventTypes, message.Type) {
+		return false
+	}
+	action := strings.SplitN(message.Action, ":", 2)[0]
+	if actions := filters.Get("event"); len(actions) > 0 && !contains(actions, action) {
+		return false
+	}
+
+	return matchLabels(message.Actor.Attributes, filters.Get("label"))
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchLabels reports whether labels match every "key" or "key=value" label filter.
+func matchLabels(labels map[string]string, labelFilters []string) bool {
+
+	for _, labelFilter := range labelFilters {
+		keyValue := strings.SplitN(labelFilter, "=", 2)
+		value, ok := labels[keyValue[0]]
+		if !ok || len(keyValue) == 2 && value != keyValue[1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// remove deletes the container from the engine. The caller holds the mutex.
+func (fake *Engine) remove(containerID string) {
+
+	delete(fake.containers, containerID)
+	for i, id := range fake.order {
+		if id == containerID {
+			fake.order = append(fake.order[:i], fake.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// lookup finds a container by ID or name. The caller holds the mutex.
+func (fake *Engine) lookup(containerID string) (*fakeContainer, bool) {
+
+	if cont, ok := fake.containers[containerID]; ok {
+		return cont, true
+	}
+	for _, cont := range fake.containers {
+		if cont.name != "" && cont.name == strings.TrimPrefix(containerID, "/") {
+			return cont, true
+		}
+	}
+
+	return nil, false
+}
+
+// ContainerRemove removes a container by ID or name, failing on a running one unless forced like the daemon does.
+func (fake *Engine) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+
+	if err := fake.wait(ctx); err != nil {
+		return err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.lookup(containerID)
+	if !ok {
+		return fmt.Errorf("No such container: %s", containerID)
+	}
+	if cont.State == stateRunning {
+		if !options.Force {
+			return fmt.Errorf("You cannot remove a running container %s. Stop the container before attempting removal or force remove", cont.ID)
+		}
+		fake.emit(cont, eventKill, map[string]string{"signal": "9"})
+		fake.exit(cont, 137)
+	}
+	fake.remove(cont.ID)
+
+	return nil
+}
+
+// ContainerInspect returns the container's config and state by ID or name.
+func (fake *Engine) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+
+	if err := ctx.Err(); err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.lookup(containerID)
+	if !ok {
+		return types.ContainerJSON{}, fmt.Errorf("No such container: %s", containerID)
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         cont.ID,
+			Name:       "/" + cont.name,
+			Image:      cont.ImageID,
+			State:      &types.ContainerState{Status: cont.State, Running: cont.State == stateRunning, ExitCode: cont.exitCode, OOMKilled: cont.oomKilled},
+			HostConfig: &cont.hostConfig,
+		},
+		Config:          &container.Config{Image: cont.Image, Labels: cont.Labels, Tty: cont.tty},
+		NetworkSettings: &types.NetworkSettings{NetworkSettingsBase: types.NetworkSettingsBase{Ports: cont.portMap()}},
+	}, nil
+}
+
+// portMap returns the host port bindings of the container's ports.
+func (cont *fakeContainer) portMap() nat.PortMap {
+
+	ports := nat.PortMap{}
+	for _, port := range cont.Ports {
+		containerPort := nat.Port(fmt.Sprintf("%d/%s", port.PrivatePort, port.Type))
+		ports[containerPort] = append(ports[containerPort], nat.PortBinding{HostIP: port.IP, HostPort: fmt.Sprintf("%d", port.PublicPort)})
+	}
+
+	return ports
+}
+
+// ContainerLogs streams the "Log line N of <name>" lines the container logs every Tick since it started,
+// every third one to stderr, from the first one or the one at options.Since on like the daemon does.
+// The lines of a non TTY container are multiplexed in frames.
+func (fake *Engine) ContainerLogs(ctx context.Context, containerID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+
+	cont, err := fake.liveContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	fake.mutex.Lock()
+	started := cont.started
+	fake.mutex.Unlock()
+	loggedAt := func(n int) time.Time {
+		return started.Add(time.Duration(n+1) * fake.Tick)
+	}
+	first := 0
+	if elapsed := parseSince(options.Since).Sub(started); options.Since != "" && elapsed > 0 {
+		first = int((elapsed+fake.Tick-1)/fake.Tick) - 1
+	}
+
+	return fake.stream(ctx, cont, first, loggedAt, func(w io.Writer, n int, timestamp time.Time) error {
+		line := fmt.Sprintf("Log line %d of %s", n, cont.name)
+		if options.Timestamps {
+			line = timestamp.UTC().Format(time.RFC3339Nano) + " " + line
+		}
+		if cont.tty {
+			_, err := io.WriteString(w, line+"\r\n")
+			return err
+		}
+		line += "\n"
+		header := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+		if n%3 == 2 {
+			header[0] = 2
+		}
+		binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	}), nil
+}
+
+// ContainerStats streams a synthetic types.StatsJSON snapshot per Tick with growing usage counters.
+// The memory and PIDs limits are the container's, the memory one 1GiB when unlimited.
+func (fake *Engine) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+
+	cont, err := fake.liveContainer(containerID)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	opened := time.Now()
+	body := fake.stream(ctx, cont, 0, func(n int) time.Time {
+		return opened.Add(time.Duration(n+1) * fake.Tick)
+	}, func(w io.Writer, n int, timestamp time.Time) error {
+		var stats types.StatsJSON
+		stats.ID = containerID
+		stats.Name = "/" + cont.name
+		stats.Read = timestamp
+		stats.PidsStats.Current = 1
+		stats.CPUStats.OnlineCPUs = 2
+		stats.CPUStats.SystemUsage = uint64(n+1) * 2000000000
+		stats.CPUStats.CPUUsage.TotalUsage = uint64(n+1) * 10000000
+		stats.PreCPUStats.SystemUsage = uint64(n) * 2000000000
+		stats.PreCPUStats.CPUUsage.TotalUsage = uint64(n) * 10000000
+		stats.MemoryStats.Usage = 4 * 1024 * 1024
+		stats.MemoryStats.Limit = 1024 * 1024 * 1024
+		if cont.hostConfig.Memory > 0 {
+			stats.MemoryStats.Limit = uint64(cont.hostConfig.Memory)
+		}
+		if cont.hostConfig.PidsLimit != nil {
+			stats.PidsStats.Limit = uint64(*cont.hostConfig.PidsLimit)
+		}
+		stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: uint64(n) * 100, TxBytes: uint64(n) * 50}}
+		return json.NewEncoder(w).Encode(&stats)
+	})
+
+	return types.ContainerStats{Body: body, OSType: "linux"}, nil
+}
+
+// ImageBuild records the build tags and responds with a json message stream.
+func (fake *Engine) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+
+	if err := fake.wait(ctx); err != nil {
+		return types.ImageBuildResponse{}, err
+	}
+
+	fake.mutex.Lock()
+	fake.BuiltImages = append(fake.BuiltImages, options.Tags...)
+	fake.mutex.Unlock()
+
+	body := fmt.Sprintf("{\"stream\":\"Successfully tagged %s\\n\"}\n", strings.Join(options.Tags, ","))
+
+	return types.ImageBuildResponse{Body: ioutil.NopCloser(strings.NewReader(body)), OSType: "linux"}, nil
+}
+
+// Close is a no-op.
+func (fake *Engine) Close() error {
+
+	return nil
+}
+
+// wait sleeps for Delay unless ctx is done first and returns the context error.
+func (fake *Engine) wait(ctx context.Context) error {
+
+	if fake.Delay > 0 {
+		timer := time.NewTimer(fake.Delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (fake *Engine) liveContainer(containerID string) (*fakeContainer, error) {
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	cont, ok := fake.containers[containerID]
+	if !ok {
+		return nil, fmt.Errorf("No such container: %s", containerID)
+	}
+	if cont.State != stateRunning {
+		return nil, fmt.Errorf("Container %s is not running", containerID)
+	}
+
+	return cont, nil
+}
+
+// DropStreams ends the log and stats streams of a running container with io.ErrUnexpectedEOF
+// as a daemon restart or a network failure would, leaving the container running.
+func (fake *Engine) DropStreams(containerID string) {
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if cont, ok := fake.containers[containerID]; ok {
+		close(cont.dropped)
+		cont.dropped = make(chan struct{})
+	}
+}
+
+// stream pipes write's nth output from first on at its time at(n), at once when past,
+// until the container stops, ctx is done or the reader is closed.
+func (fake *Engine) stream(ctx context.Context, cont *fakeContainer, first int, at func(n int) time.Time, write func(w io.Writer, n int, timestamp time.Time) error) io.ReadCloser {
+
+	reader, writer := io.Pipe()
+
+	fake.mutex.Lock()
+	dropped := cont.dropped
+	fake.mutex.Unlock()
+
+	go func() {
+		for n := first; ; n++ {
+			timestamp := at(n)
+			timer := time.NewTimer(time.Until(timestamp))
+			select {
+			case <-cont.stopped:
+				timer.Stop()
+				writer.Close()
+				return
+			case <-dropped:
+				timer.Stop()
+				writer.CloseWithError(io.ErrUnexpectedEOF)
+				return
+			case <-ctx.Done():
+				timer.Stop()
+				writer.CloseWithError(ctx.Err())
+				return
+			case <-timer.C:
+				if err := write(writer, n, timestamp); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return reader
+}
+
+// parseSince parses the since option of the logs and events requests, an RFC 3339 time
+// or Unix seconds.nanoseconds, as the zero time when empty or invalid.
+func parseSince(since string) time.Time {
+
+	if timestamp, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return timestamp
+	}
+	var seconds, nanoseconds int64
+	if n, _ := fmt.Sscanf(since, "%d.%d", &seconds, &nanoseconds); n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, nanoseconds)
+}
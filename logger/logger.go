@@ -2,44 +2,281 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Logger is the file system sink for log messages.
+// Buffering defaults of a zero Options.
+const (
+	DefaultBufferSize    = 4096
+	DefaultFlushInterval = time.Second
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// Severity levels, least severe first.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+
+	return levelNames[level]
+}
+
+// ParseLevel returns the level named debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, want debug, info, warn or error", name)
+}
+
+// Options configures a Logger. The zero Options logs every level to a file growing without limit.
+type Options struct {
+	// Rotation applies to the log file of GetLoggerWith.
+	Rotation Rotation
+	// Level is the least severe level written.
+	Level Level
+	// BufferSize is the number of messages queued to each sink. Messages beyond are dropped.
+	BufferSize int
+	// FlushInterval is the longest time a written message stays buffered.
+	FlushInterval time.Duration
+}
+
+// Logger writes log messages to one or more sinks.
+// Messages are queued without blocking to a goroutine per sink, so a slow disk or collector
+// drops its messages rather than stalling the callers or the other sinks. It is safe for concurrent use.
 type Logger struct {
-	logFile *os.File
+	level   Level
+	writers []*sinkWriter
+}
+
+// sinkWriter owns a sink and the queue of messages to write to it.
+type sinkWriter struct {
+	sink  Sink
+	queue chan Message
+	done  chan struct{}
+	// mutex guards closed; Close holds it while closing queue.
+	mutex  sync.RWMutex
+	closed bool
+	// message counters, accessed atomically
+	written uint64
+	dropped uint64
+	failed  uint64
 }
 
 // GetLogger returns a new logger for a given name in the process working directory.
 func GetLogger(logFilename string) Logger {
 
+	return GetLoggerWith(logFilename, Options{})
+}
+
+// GetLoggerWith returns a new logger for a given name configured by options.
+func GetLoggerWith(logFilename string, options Options) Logger {
+
 	logger := Logger{}
-	logger.open(logFilename)
+	logger.open(logFilename, options)
 
 	return logger
 }
 
 // Open creates/opens the requested filesystem logFilePathName with
-// os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666 parameters.
-func (logger *Logger) open(logFilePathName string) {
+// os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666 parameters and starts its writer.
+func (logger *Logger) open(logFilePathName string, options Options) {
 
-	var err error
-	logger.logFile, err = os.OpenFile(logFilePathName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	fileSink, err := OpenFileSink(logFilePathName, options.Rotation)
 
 	if err != nil {
 		panic(fmt.Sprintf("Error %s opening logging filename: %v", err, logFilePathName))
 	}
 
+	*logger = NewLogger(options, fileSink)
 }
 
-// Println calls Output to print to this filesystem log file and stdout.
+// NewLogger returns a new logger writing to sinks configured by options.
+func NewLogger(options Options, sinks ...Sink) Logger {
+
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+
+	logger := Logger{level: options.Level}
+	for _, sink := range sinks {
+		w := &sinkWriter{
+			sink:  sink,
+			queue: make(chan Message, options.BufferSize),
+			done:  make(chan struct{}),
+		}
+		go w.write(options.FlushInterval)
+		logger.writers = append(logger.writers, w)
+	}
+
+	return logger
+}
+
+// Println queues an info message to the sinks.
 // Arguments are handled in the manner of fmt.Println.
 func (logger *Logger) Println(v ...interface{}) {
-	fmt.Fprintln(logger.logFile, v...)
+
+	logger.Log(LevelInfo, v...)
+}
+
+// Log queues a level message to the sinks unless level is below the logger's level.
+// Arguments are handled in the manner of fmt.Println.
+// The message is dropped by the sinks whose queue is full and when the logger is closed.
+func (logger *Logger) Log(level Level, v ...interface{}) {
+
+	if level < logger.level {
+		return
+	}
+
+	message := Message{Level: level, Time: time.Now(), Text: strings.TrimSuffix(fmt.Sprintln(v...), "\n")}
+	for _, w := range logger.writers {
+		w.enqueue(message)
+	}
+}
+
+func (w *sinkWriter) enqueue(message Message) {
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if !w.closed {
+		select {
+		case w.queue <- message:
+			return
+		default:
+		}
+	}
+	atomic.AddUint64(&w.dropped, 1)
+}
+
+// write writes the queued messages to the sink until close, flushing them every flushInterval.
+func (w *sinkWriter) write(flushInterval time.Duration) {
+
+	defer close(w.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	fail := func(err error) {
+		atomic.AddUint64(&w.failed, 1)
+		log.Printf("Error writing log to %s: %v\n", w.sink, err)
+	}
+
+	var reportedDropped uint64
+	flush := func() {
+		if err := w.sink.Flush(); err != nil {
+			fail(err)
+		}
+		if dropped := atomic.LoadUint64(&w.dropped); dropped > reportedDropped {
+			log.Printf("Log to %s dropped %d messages.\n", w.sink, dropped-reportedDropped)
+			reportedDropped = dropped
+		}
+	}
+
+	for {
+		select {
+		case message, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if err := w.sink.Write(message); err != nil {
+				fail(err)
+				continue
+			}
+			atomic.AddUint64(&w.written, 1)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Stats counts the messages of a Logger across its sinks.
+type Stats struct {
+	// Written messages were accepted by a sink.
+	Written uint64
+	// Dropped messages found a sink queue full or the logger closed.
+	Dropped uint64
+	// Failed counts the failed sink writes and flushes, each losing the messages buffered at the time.
+	Failed uint64
 }
 
-// Close closes the open file handle
-func (logger *Logger) Close() {
-	logger.logFile.Close()
+// Stats returns the message counts so far.
+func (logger *Logger) Stats() Stats {
+
+	var stats Stats
+	for _, w := range logger.writers {
+		stats.Written += atomic.LoadUint64(&w.written)
+		stats.Dropped += atomic.LoadUint64(&w.dropped)
+		stats.Failed += atomic.LoadUint64(&w.failed)
+	}
+
+	return stats
+}
+
+// Close writes the queued messages, closes the sinks and reports any dropped
+// or failed messages as an error. Messages logged after Close are dropped.
+func (logger *Logger) Close() error {
+
+	var errs []string
+	for _, w := range logger.writers {
+		if err := w.close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (w *sinkWriter) close() error {
+
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mutex.Unlock()
+
+	<-w.done
+	err := w.sink.Close()
+
+	dropped, failed := atomic.LoadUint64(&w.dropped), atomic.LoadUint64(&w.failed)
+	if dropped > 0 || failed > 0 {
+		return fmt.Errorf("log to %s: %d messages dropped, %d failed writes", w.sink, dropped, failed)
+	}
+	if err != nil {
+		return fmt.Errorf("log to %s: %w", w.sink, err)
+	}
+
+	return nil
 }
@@ -3,8 +3,12 @@ package logger
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 	"tlex/helper"
 )
 
@@ -40,4 +44,84 @@ func TestGetLogger(t *testing.T) {
 		t.Errorf("Size %v is not 0 for the new log file of getLogger()\n", sz)
 	}
 	file.Close()
-}
\ No newline at end of file
+}
+
+// tempLogFilename returns a log file name in a new temp dir.
+func tempLogFilename(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "tlex-logger")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	return filepath.Join(dir, "containers.log"), func() { os.RemoveAll(dir) }
+}
+
+func Test_Logger_Flushes_On_Interval_And_Close(t *testing.T) {
+
+	logFilename, cleanup := tempLogFilename(t)
+	defer cleanup()
+
+	logger := GetLoggerWith(logFilename, Options{Level: LevelInfo, FlushInterval: 10 * time.Millisecond})
+	logger.Println("first")
+	logger.Log(LevelDebug, "below the level")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for content, _ := ioutil.ReadFile(logFilename); string(content) != "first\n"; content, _ = ioutil.ReadFile(logFilename) {
+		if time.Now().After(deadline) {
+			t.Fatalf("log file = %q before Close, want first flushed", content)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	logger.Log(LevelError, "last")
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(logFilename); string(content) != "first\nlast\n" {
+		t.Errorf("log file = %q after Close, want first and last", content)
+	}
+	if stats := logger.Stats(); stats != (Stats{Written: 2}) {
+		t.Errorf("Stats() = %+v, want 2 written", stats)
+	}
+}
+
+func Test_Logger_Drops_When_Full_And_Reports_On_Close(t *testing.T) {
+
+	logFilename, cleanup := tempLogFilename(t)
+	defer cleanup()
+
+	fileSink, err := OpenFileSink(logFilename, Rotation{})
+	if err != nil {
+		t.Fatalf("OpenFileSink() error = %v", err)
+	}
+	// No writer goroutine drains the queue until both lines are logged.
+	w := &sinkWriter{sink: fileSink, queue: make(chan Message, 1), done: make(chan struct{})}
+	logger := Logger{writers: []*sinkWriter{w}}
+	logger.Println("queued")
+	logger.Println("dropped")
+	go w.write(time.Hour)
+
+	if err = logger.Close(); err == nil || !strings.Contains(err.Error(), "1 messages dropped") {
+		t.Errorf("Close() error = %v, want 1 messages dropped", err)
+	}
+	logger.Println("after close")
+	if stats := logger.Stats(); stats != (Stats{Written: 1, Dropped: 2}) {
+		t.Errorf("Stats() = %+v, want 1 written and 2 dropped", stats)
+	}
+	if content, _ := ioutil.ReadFile(logFilename); string(content) != "queued\n" {
+		t.Errorf("log file = %q, want queued", content)
+	}
+}
+
+func Test_ParseLevel(t *testing.T) {
+
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if parsed, err := ParseLevel(strings.ToUpper(level.String())); err != nil || parsed != level {
+			t.Errorf("ParseLevel(%s) = %v, %v", level, parsed, err)
+		}
+	}
+	if _, err := ParseLevel("fatal"); err == nil {
+		t.Errorf("ParseLevel(fatal) did not fail")
+	}
+}
@@ -0,0 +1,264 @@
+// Package logger abstracts the filesystem file logging creation specifics + text file reference + light api.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Network sinks timeouts.
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+	postTimeout  = 10 * time.Second
+)
+
+// tcpFlushSize flushes the lines buffered by a TCP sink before the flush interval.
+const tcpFlushSize = 64 * 1024
+
+// DefaultBatchSize is the number of messages per HTTP POST of a zero batch size.
+const DefaultBatchSize = 100
+
+// syslogFacility is the RFC 5424 user-level messages facility.
+const syslogFacility = 1
+
+// syslogSeverity maps levels to RFC 5424 severities.
+var syslogSeverity = map[Level]int{LevelDebug: 7, LevelInfo: 6, LevelWarn: 4, LevelError: 3}
+
+// netConn dials its address on the first write and redials after a failed write,
+// so a collector down at start or restarted loses only the messages sent meanwhile.
+type netConn struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+func (c *netConn) write(p []byte) error {
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout(c.network, c.address, dialTimeout)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(p); err != nil {
+		c.close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *netConn) close() error {
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+// syslogSink sends each message as an RFC 5424 syslog message, a datagram per message over udp
+// and octet counting framed (RFC 6587) over tcp.
+type syslogSink struct {
+	netConn
+	hostname string
+	appName  string
+	procID   int
+}
+
+// NewSyslogSink returns a sink sending messages of appName to the syslog server at address over network udp or tcp.
+func NewSyslogSink(network string, address string, appName string) Sink {
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogSink{
+		netConn:  netConn{network: network, address: address},
+		hostname: hostname,
+		appName:  appName,
+		procID:   os.Getpid(),
+	}
+}
+
+// format renders message as <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG.
+func (sink *syslogSink) format(message Message) string {
+
+	severity, ok := syslogSeverity[message.Level]
+	if !ok {
+		severity = syslogSeverity[LevelInfo]
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s", syslogFacility*8+severity,
+		message.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), sink.hostname, sink.appName, sink.procID, message.Text)
+}
+
+func (sink *syslogSink) Write(message Message) error {
+
+	syslogMessage := sink.format(message)
+	if sink.network == "tcp" {
+		syslogMessage = fmt.Sprintf("%d %s", len(syslogMessage), syslogMessage)
+	}
+
+	return sink.write([]byte(syslogMessage))
+}
+
+func (sink *syslogSink) Flush() error {
+
+	return nil
+}
+
+func (sink *syslogSink) Close() error {
+
+	return sink.close()
+}
+
+func (sink *syslogSink) String() string {
+
+	return fmt.Sprintf("syslog+%s://%s", sink.network, sink.address)
+}
+
+// tcpSink sends newline terminated message lines over tcp.
+type tcpSink struct {
+	netConn
+	buffer bytes.Buffer
+}
+
+// NewTCPSink returns a sink sending message lines to address over tcp.
+func NewTCPSink(address string) Sink {
+
+	return &tcpSink{netConn: netConn{network: "tcp", address: address}}
+}
+
+func (sink *tcpSink) Write(message Message) error {
+
+	sink.buffer.WriteString(message.Text)
+	sink.buffer.WriteByte('\n')
+	if sink.buffer.Len() >= tcpFlushSize {
+		return sink.Flush()
+	}
+
+	return nil
+}
+
+// Flush sends the buffered lines, which are dropped if sending fails.
+func (sink *tcpSink) Flush() error {
+
+	if sink.buffer.Len() == 0 {
+		return nil
+	}
+	defer sink.buffer.Reset()
+
+	return sink.write(sink.buffer.Bytes())
+}
+
+func (sink *tcpSink) Close() error {
+
+	err := sink.Flush()
+	if closeErr := sink.close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (sink *tcpSink) String() string {
+
+	return "tcp://" + sink.address
+}
+
+// httpMessage is a message of an HTTP sink batch.
+type httpMessage struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// httpSink POSTs batches of messages as a JSON array.
+type httpSink struct {
+	url       string
+	client    *http.Client
+	batchSize int
+	batch     []httpMessage
+}
+
+// NewHTTPSink returns a sink POSTing up to batchSize messages at a time to url as a JSON array of
+// {"time","level","message"} objects. A batch is posted when full or flushed.
+func NewHTTPSink(url string, batchSize int) Sink {
+
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &httpSink{url: url, client: &http.Client{Timeout: postTimeout}, batchSize: batchSize}
+}
+
+func (sink *httpSink) Write(message Message) error {
+
+	sink.batch = append(sink.batch, httpMessage{Time: message.Time.UTC(), Level: message.Level.String(), Message: message.Text})
+	if len(sink.batch) >= sink.batchSize {
+		return sink.Flush()
+	}
+
+	return nil
+}
+
+// Flush posts the batch, which is dropped if posting fails.
+func (sink *httpSink) Flush() error {
+
+	if len(sink.batch) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(sink.batch)
+	sink.batch = sink.batch[:0]
+	if err != nil {
+		return err
+	}
+
+	response, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(body))
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = sink.String()
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", sink, response.Status)
+	}
+
+	return nil
+}
+
+func (sink *httpSink) Close() error {
+
+	return sink.Flush()
+}
+
+// String returns the scheme and host of the url only, its credentials, path and query may hold secrets.
+func (sink *httpSink) String() string {
+
+	parsed, err := url.Parse(sink.url)
+	if err != nil {
+		return "http"
+	}
+
+	return parsed.Scheme + "://" + parsed.Host
+}
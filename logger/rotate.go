@@ -0,0 +1,299 @@
+// Package logger abstracts the filesystem file logging creation specifics + text file reference + light api.
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeLayout suffixes a rotated file name e.g. containers.log.20200102T030405.000000000[.gz].
+// It sorts rotated files oldest first.
+const rotatedTimeLayout = "20060102T150405.000000000"
+
+const gzipExt = ".gz"
+
+// Rotation configures when a log file is rotated and how many rotated files are retained.
+// The zero Rotation grows the file without limit.
+type Rotation struct {
+	// MaxSize rotates the file before a write grows it past MaxSize bytes. 0 disables.
+	MaxSize int64
+	// MaxAge rotates the file once it has been written to for MaxAge, across the processes appending to it. 0 disables.
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files retained. 0 retains all.
+	MaxFiles int
+	// Compress gzips the rotated files in the background.
+	Compress bool
+}
+
+// compressFile gzips a rotated file. Tests replace it to hold the compression up.
+var compressFile = compress
+
+// rotatingFile is a log file appended to under a lock and rotated per its Rotation.
+type rotatingFile struct {
+	mutex    sync.Mutex
+	filename string
+	rotation Rotation
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+	// compressing counts the background compressions, run one at a time under compressMutex.
+	compressing   sync.WaitGroup
+	compressMutex sync.Mutex
+	// compressErr is the last background compression failure, returned by the next Write or Close.
+	compressErr error
+}
+
+func openRotatingFile(filename string, rotation Rotation) (*rotatingFile, error) {
+
+	rf := &rotatingFile{filename: filename, rotation: rotation, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// open creates/opens the file with os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666 parameters.
+func (rf *rotatingFile) open() error {
+
+	file, err := os.OpenFile(rf.filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = rf.now()
+	if rf.size > 0 {
+		rf.openedAt = rf.startedAt(info)
+	}
+
+	return nil
+}
+
+// startedAt returns when the existing file info was started: when the previous file was rotated or,
+// without a rotated file, at its last write. Its age thus does not restart with every process appending to it.
+func (rf *rotatingFile) startedAt(info os.FileInfo) time.Time {
+
+	rotated, err := rotatedFiles(rf.filename)
+	if err == nil && len(rotated) > 0 {
+		suffix := strings.TrimPrefix(filepath.Base(rotated[len(rotated)-1]), filepath.Base(rf.filename)+".")
+		if rotatedAt, err := time.Parse(rotatedTimeLayout, strings.TrimSuffix(suffix, gzipExt)); err == nil {
+			return rotatedAt
+		}
+	}
+
+	return info.ModTime()
+}
+
+// Write appends p to the file, rotating it first when due.
+// A failed rotation is returned and the write goes to the current file.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	rotateErr := rf.compressErr
+	rf.compressErr = nil
+	if rf.due(len(p)) {
+		if err := rf.rotate(); err != nil {
+			rotateErr = err
+		}
+		if rf.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+
+	return n, err
+}
+
+// due tells whether the file is to be rotated before writing size more bytes.
+// An empty file is never rotated.
+func (rf *rotatingFile) due(size int) bool {
+
+	if rf.size == 0 {
+		return false
+	}
+	if rf.rotation.MaxSize > 0 && rf.size+int64(size) > rf.rotation.MaxSize {
+		return true
+	}
+
+	return rf.rotation.MaxAge > 0 && rf.now().Sub(rf.openedAt) >= rf.rotation.MaxAge
+}
+
+// rotate renames the file with a time suffix, removes the rotated files beyond MaxFiles and reopens
+// an empty file. A compressed rotation is pruned once compressed in the background, see compressInBackground.
+func (rf *rotatingFile) rotate() error {
+
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	rotated := rf.filename + "." + rf.now().UTC().Format(rotatedTimeLayout)
+	err := os.Rename(rf.filename, rotated)
+	switch {
+	case err != nil:
+	case rf.rotation.Compress:
+		rf.compressInBackground(rotated)
+	default:
+		err = rf.prune()
+	}
+
+	if openErr := rf.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+// compressInBackground gzips the rotated file then prunes the rotated files so that a large
+// rotated file does not hold up the writes.
+func (rf *rotatingFile) compressInBackground(rotated string) {
+
+	rf.compressing.Add(1)
+	go func() {
+		defer rf.compressing.Done()
+
+		rf.compressMutex.Lock()
+		defer rf.compressMutex.Unlock()
+
+		err := compressFile(rotated)
+		if err == nil {
+			err = rf.prune()
+		}
+		if err != nil {
+			rf.mutex.Lock()
+			rf.compressErr = err
+			rf.mutex.Unlock()
+		}
+	}()
+}
+
+// compress gzips filename to filename.gz and removes filename.
+func compress(filename string) error {
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filename+gzipExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename + gzipExt)
+		return fmt.Errorf("unable to compress %s: %w", filename, err)
+	}
+	src.Close()
+
+	return os.Remove(filename)
+}
+
+// prune removes the oldest rotated files beyond MaxFiles.
+func (rf *rotatingFile) prune() error {
+
+	if rf.rotation.MaxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := rotatedFiles(rf.filename)
+	if err != nil {
+		return err
+	}
+
+	for len(rotated) > rf.rotation.MaxFiles {
+		if err = os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+// rotatedFiles returns the rotated files of filename oldest first.
+func rotatedFiles(filename string) ([]string, error) {
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), gzipExt)
+		if _, err := time.Parse(rotatedTimeLayout, suffix); err == nil {
+			rotated = append(rotated, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(rotated)
+
+	return rotated, nil
+}
+
+// Close waits for the background compressions and closes the file.
+func (rf *rotatingFile) Close() error {
+
+	rf.compressing.Wait()
+
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	err := rf.compressErr
+	rf.compressErr = nil
+	if rf.file == nil {
+		return err
+	}
+	if closeErr := rf.file.Close(); closeErr != nil {
+		err = closeErr
+	}
+	rf.file = nil
+
+	return err
+}
@@ -0,0 +1,185 @@
+// Package logger abstracts the filesystem file logging creation specifics + text file reference + light api.
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tempRotatingFile opens a rotating file in a temp dir with a clock advancing a millisecond per reading.
+func tempRotatingFile(t *testing.T, rotation Rotation) (*rotatingFile, func()) {
+
+	dir, err := ioutil.TempDir("", "tlex-logger")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	rf, err := openRotatingFile(filepath.Join(dir, "containers.log"), rotation)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rf.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	rf.openedAt = rf.now()
+
+	return rf, func() { rf.Close(); os.RemoveAll(dir) }
+}
+
+func Test_Rotate_By_Size_Retains_MaxFiles(t *testing.T) {
+
+	rf, cleanup := tempRotatingFile(t, Rotation{MaxSize: 10, MaxFiles: 2})
+	defer cleanup()
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	rotated, err := rotatedFiles(rf.filename)
+	if err != nil {
+		t.Fatalf("rotatedFiles() error = %v", err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("retained %d rotated files %v, want 2", len(rotated), rotated)
+	}
+	for i, want := range []string{"line 2\n", "line 3\n"} {
+		if content, _ := ioutil.ReadFile(rotated[i]); string(content) != want {
+			t.Errorf("rotated file %d = %q, want %q", i, content, want)
+		}
+	}
+	if content, _ := ioutil.ReadFile(rf.filename); string(content) != "line 4\n" {
+		t.Errorf("current file = %q, want line 4", content)
+	}
+}
+
+func Test_Rotate_By_Age_Compressed(t *testing.T) {
+
+	rf, cleanup := tempRotatingFile(t, Rotation{MaxAge: time.Millisecond, Compress: true})
+	defer cleanup()
+
+	rf.Write([]byte("old\n"))
+	if _, err := rf.Write([]byte("new\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	rf.compressing.Wait()
+
+	rotated, _ := rotatedFiles(rf.filename)
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], gzipExt) {
+		t.Fatalf("rotated files = %v, want 1 gzipped", rotated)
+	}
+
+	gzipped, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer gzipped.Close()
+	zr, err := gzip.NewReader(gzipped)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	if content, _ := ioutil.ReadAll(zr); string(content) != "old\n" {
+		t.Errorf("rotated file = %q, want old", content)
+	}
+	if content, _ := ioutil.ReadFile(rf.filename); string(content) != "new\n" {
+		t.Errorf("current file = %q, want new", content)
+	}
+}
+
+func Test_Writes_Go_On_While_Compressing(t *testing.T) {
+
+	compressing := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	compressFile = func(filename string) error {
+		once.Do(func() { close(compressing) })
+		<-release
+		return compress(filename)
+	}
+	defer func() { compressFile = compress }()
+
+	rf, cleanup := tempRotatingFile(t, Rotation{MaxSize: 4, Compress: true})
+	defer cleanup()
+
+	rf.Write([]byte("old\n"))
+	rf.Write([]byte("new\n"))
+	<-compressing
+	written := make(chan error)
+	go func() {
+		_, err := rf.Write([]byte("more\n"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("Write() while compressing error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write() waited for the compression")
+	}
+	close(release)
+
+	if err := rf.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	rotated, _ := rotatedFiles(rf.filename)
+	if len(rotated) != 2 || !strings.HasSuffix(rotated[0], gzipExt) || !strings.HasSuffix(rotated[1], gzipExt) {
+		t.Errorf("rotated files = %v, want 2 gzipped once closed", rotated)
+	}
+}
+
+func Test_Reopened_File_Keeps_Its_Age(t *testing.T) {
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	tests := []struct {
+		name string
+		// rotatedAt names a rotated file, if any.
+		rotatedAt time.Time
+		modTime   time.Time
+	}{
+		{"last written long ago", time.Time{}, old},
+		{"rotated long ago", old, time.Now()},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "tlex-logger")
+		if err != nil {
+			t.Fatalf("TempDir() error = %v", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "containers.log")
+		if !tt.rotatedAt.IsZero() {
+			ioutil.WriteFile(filename+"."+tt.rotatedAt.UTC().Format(rotatedTimeLayout), []byte("older\n"), 0666)
+		}
+		if err = ioutil.WriteFile(filename, []byte("old\n"), 0666); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		os.Chtimes(filename, tt.modTime, tt.modTime)
+
+		rf, err := openRotatingFile(filename, Rotation{MaxAge: time.Hour})
+		if err != nil {
+			t.Fatalf("%s: openRotatingFile() error = %v", tt.name, err)
+		}
+		rotatedBefore, _ := rotatedFiles(filename)
+		if _, err = rf.Write([]byte("new\n")); err != nil {
+			t.Errorf("%s: Write() error = %v", tt.name, err)
+		}
+		rf.Close()
+		if rotated, _ := rotatedFiles(filename); len(rotated) != len(rotatedBefore)+1 {
+			t.Errorf("%s: reopened file not rotated, %d rotated files, want %d", tt.name, len(rotated), len(rotatedBefore)+1)
+		}
+		if content, _ := ioutil.ReadFile(filename); string(content) != "new\n" {
+			t.Errorf("%s: current file = %q, want new", tt.name, content)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package logger abstracts the filesystem file logging creation specifics + text file reference + light api.
+package logger
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// Message is a log message without its line ending.
+type Message struct {
+	Level Level
+	Time  time.Time
+	Text  string
+}
+
+// Sink is a destination of log messages.
+// A Logger calls a sink from a single goroutine.
+type Sink interface {
+	// Write writes or buffers a message.
+	Write(message Message) error
+	// Flush writes the buffered messages.
+	Flush() error
+	// Close flushes the buffered messages and releases the sink.
+	Close() error
+	// String names the destination in error reports e.g. file containers.log.
+	String() string
+}
+
+// writerSink buffers message lines to an io.Writer.
+type writerSink struct {
+	name   string
+	writer io.Writer
+	closer io.Closer
+	buffer *bufio.Writer
+}
+
+// OpenFileSink creates/opens the filesystem logFilePathName for appending lines rotated per rotation.
+func OpenFileSink(logFilePathName string, rotation Rotation) (Sink, error) {
+
+	logFile, err := openRotatingFile(logFilePathName, rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWriterSink("file "+logFilePathName, logFile, logFile), nil
+}
+
+// NewStdoutSink returns a sink writing lines to stdout.
+func NewStdoutSink() Sink {
+
+	return newWriterSink("stdout", os.Stdout, nil)
+}
+
+func newWriterSink(name string, writer io.Writer, closer io.Closer) *writerSink {
+
+	return &writerSink{name: name, writer: writer, closer: closer, buffer: bufio.NewWriter(writer)}
+}
+
+func (sink *writerSink) Write(message Message) error {
+
+	_, err := sink.buffer.WriteString(message.Text + "\n")
+	if err != nil {
+		sink.reset()
+	}
+
+	return err
+}
+
+func (sink *writerSink) Flush() error {
+
+	err := sink.buffer.Flush()
+	if err != nil {
+		sink.reset()
+	}
+
+	return err
+}
+
+// reset drops the buffered lines of a failed write so the next lines are retried.
+func (sink *writerSink) reset() {
+
+	sink.buffer = bufio.NewWriter(sink.writer)
+}
+
+func (sink *writerSink) Close() error {
+
+	err := sink.Flush()
+	if sink.closer != nil {
+		if closeErr := sink.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (sink *writerSink) String() string {
+
+	return sink.name
+}
+
+// levelSink drops the messages below its level.
+type levelSink struct {
+	Sink
+	level Level
+}
+
+// MinLevel returns a sink writing the messages of level or above to sink.
+func MinLevel(sink Sink, level Level) Sink {
+
+	return &levelSink{Sink: sink, level: level}
+}
+
+func (sink *levelSink) Write(message Message) error {
+
+	if message.Level < sink.level {
+		return nil
+	}
+
+	return sink.Sink.Write(message)
+}
@@ -2,19 +2,79 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
 	"tlex/config"
-	"tlex/dockerapi"
 	wk "tlex/workflow"
 )
 
-// Cleanup previous owned live instances that might have been left hanging.
-func init() {
+// command is a tlex subcommand acting on the loaded configuration.
+type command struct {
+	name  string
+	usage string
+	run   func(context.Context, config.AppConfig) error
+}
+
+var commands = []command{
+	{"run", "build, launch, monitor and remove the containers in the foreground (default)", wk.Run},
+	{"build", "build the Docker image", wk.Build},
+	{"up", "launch the containers and detach", wk.Up},
+	{"status", "list the owned containers", wk.Status},
+	{"logs", "follow the owned containers' logs", wk.Logs},
+	{"stats", "follow the owned containers' stats", wk.Stats},
+	{"down", "stop the owned containers", wk.Down},
+}
+
+func usage() {
 
-	dockerapi.RemoveLiveContainersFromPreviousRun()
+	fmt.Fprintf(os.Stderr, "Usage: tlex [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun tlex <command> -h for the configuration flags.\n")
 }
 
 func main() {
 
-	wk.Workflow(config.GetConfig())
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		cfg, err := config.Load("tlex "+name, args)
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		if err != nil {
+			log.Fatalf("Unable to load the configuration: %v\n", err)
+		}
+
+		// Cancelled upon the interrupt signal
+		ctx, cancel := wk.SetupTerminateSignal(context.Background())
+		err = cmd.run(ctx, cfg)
+		cancel()
+		if errors.Is(err, context.Canceled) {
+			log.Fatalf("tlex %s: interrupted\n", name)
+		}
+		if err != nil {
+			log.Fatalf("tlex %s: %v\n", name, err)
+		}
+		return
+	}
 
+	usage()
+	if name != "help" {
+		os.Exit(2)
+	}
 }
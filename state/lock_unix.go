@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+// Package state persists the containers owned by a launch to a versioned JSON state file
+// and migrates the legacy ids.gob map of container ids to host ports.
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lock takes an exclusive advisory lock on the folder of the state filename.
+// Locking the folder instead of a lock file leaves no stale files behind.
+func lock(filename string) (unlock func(), err error) {
+
+	dir, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock the state file: %w", err)
+	}
+	if err = syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("unable to lock the state file: %w", err)
+	}
+
+	return func() {
+		syscall.Flock(int(dir.Fd()), syscall.LOCK_UN)
+		dir.Close()
+	}, nil
+}
@@ -0,0 +1,28 @@
+// Package state persists the containers owned by a launch to a versioned JSON state file
+// and migrates the legacy ids.gob map of container ids to host ports.
+package state
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockWait bounds waiting for another process to release the lock.
+const lockWait = 10 * time.Second
+
+// lock creates the exclusive filename.lock file, waiting up to lockWait for another process to remove it.
+func lock(filename string) (unlock func(), err error) {
+
+	lockFilename := filename + ".lock"
+	for deadline := time.Now().Add(lockWait); ; time.Sleep(50 * time.Millisecond) {
+		lockFile, err := os.OpenFile(lockFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err == nil {
+			lockFile.Close()
+			return func() { os.Remove(lockFilename) }, nil
+		}
+		if !os.IsExist(err) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("unable to lock the state file, remove %s if no tlex is running: %w", lockFilename, err)
+		}
+	}
+}
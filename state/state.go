@@ -0,0 +1,213 @@
+// Package state persists the containers owned by a launch to a versioned JSON state file
+// and migrates the legacy ids.gob map of container ids to host ports.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"tlex/mapsi2disk"
+)
+
+// Version is the state file format version written by Save.
+const Version = 1
+
+// Filename is the filename without path of the state file.
+const Filename = "tlex_state.json"
+
+// TlexVersion is the tlex release recorded in the state file.
+// Set it at build time with -ldflags "-X tlex/state.TlexVersion=<version>".
+var TlexVersion = "dev"
+
+// Container is an owned container entry of the state file.
+type Container struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	HostPort    int    `json:"hostPort"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// CreatedAt is the zero time for a container migrated from ids.gob.
+	CreatedAt time.Time `json:"createdAt"`
+	RunID     string    `json:"runId,omitempty"`
+	// Restarts counts the relaunches of dead containers at the host port.
+	Restarts int `json:"restarts,omitempty"`
+}
+
+// File is the state file document.
+type File struct {
+	Version     int         `json:"version"`
+	TlexVersion string      `json:"tlexVersion"`
+	RunID       string      `json:"runId,omitempty"`
+	SavedAt     time.Time   `json:"savedAt"`
+	Containers  []Container `json:"containers"`
+}
+
+// RunIDs returns the run ids of the recorded launches, those of relaunched containers included.
+func (state *File) RunIDs() []string {
+
+	runIDs := []string{}
+	seen := map[string]bool{"": true}
+	add := func(runID string) {
+		if !seen[runID] {
+			seen[runID] = true
+			runIDs = append(runIDs, runID)
+		}
+	}
+	add(state.RunID)
+	for _, cont := range state.Containers {
+		add(cont.RunID)
+	}
+
+	return runIDs
+}
+
+// Save writes the state document to filename atomically under the state file lock.
+// The document is written to a temporary file in the same folder which then replaces filename,
+// so a crash leaves either the previous or the new state.
+func Save(filename string, state *File) error {
+
+	unlock, err := lock(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return save(filename, state)
+}
+
+// save writes the state document atomically. The caller holds the lock.
+func save(filename string, state *File) error {
+
+	state.Version = Version
+	state.TlexVersion = TlexVersion
+	state.SavedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode the state: %w", err)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create the state file: %w", err)
+	}
+	tmpFilename := tmpFile.Name()
+
+	_, err = tmpFile.Write(append(data, '\n'))
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("unable to write the state file %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// Load reads the state document from filename.
+// A legacy ids.gob file in the same folder is migrated to filename when filename does not exist.
+// It returns an error matching os.ErrNotExist when there is no state.
+func Load(filename string) (*File, error) {
+
+	state, err := read(filename)
+	if !errors.Is(err, os.ErrNotExist) {
+		return state, err
+	}
+
+	gobFilename := filepath.Join(filepath.Dir(filename), mapsi2disk.GobFilename)
+	if _, statErr := os.Stat(gobFilename); statErr != nil {
+		return nil, err
+	}
+
+	return migrate(filename, gobFilename)
+}
+
+func read(filename string) (*File, error) {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &File{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupt state file %s: %w", filename, err)
+	}
+	if state.Version > Version {
+		return nil, fmt.Errorf("state file %s version %d is newer than the supported version %d", filename, state.Version, Version)
+	}
+
+	return state, nil
+}
+
+// migrate converts the legacy gob map of container ids to host ports into the state file
+// and deletes the gob file.
+func migrate(filename string, gobFilename string) (*File, error) {
+
+	unlock, err := lock(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Migrated by another process while waiting for the lock.
+	if state, err := read(filename); !errors.Is(err, os.ErrNotExist) {
+		return state, err
+	}
+
+	readObj, err := mapsi2disk.ReadContainerPortsFromDisk(gobFilename)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt legacy state file %s: %w", gobFilename, err)
+	}
+
+	// ReadContainerPortsFromDisk decodes a map[string]int only.
+	legacy, _ := readObj.(map[string]int)
+
+	state := &File{Containers: []Container{}}
+	for containerID, hostPort := range legacy {
+		state.Containers = append(state.Containers, Container{ID: containerID, HostPort: hostPort})
+	}
+	sort.Slice(state.Containers, func(i, j int) bool {
+		return state.Containers[i].HostPort < state.Containers[j].HostPort
+	})
+	if err = save(filename, state); err != nil {
+		return nil, err
+	}
+	log.Printf("Migrated %s to %s.\n", gobFilename, filename)
+	mapsi2disk.DeleteFile(gobFilename)
+
+	return state, nil
+}
+
+// Delete removes the state file and any legacy ids.gob file in the same folder under the state file lock.
+// Missing files are not an error.
+func Delete(filename string) error {
+
+	unlock, err := lock(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	gobFilename := filepath.Join(filepath.Dir(filename), mapsi2disk.GobFilename)
+	for _, name := range []string{filename, gobFilename} {
+		if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete the state file: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package state persists the containers owned by a launch to a versioned JSON state file
+// and migrates the legacy ids.gob map of container ids to host ports.
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tlex/mapsi2disk"
+)
+
+func tempStateFilename(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "tlex-state")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	return filepath.Join(dir, Filename), func() { os.RemoveAll(dir) }
+}
+
+func Test_Save_Then_Load(t *testing.T) {
+
+	filename, cleanup := tempStateFilename(t)
+	defer cleanup()
+
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	saved := &File{RunID: "run", Containers: []Container{
+		{ID: "a1", Name: "HttpServerAt_8770", HostPort: 8770, ImageDigest: "sha256:01", CreatedAt: createdAt, RunID: "run"},
+		{ID: "b2", Name: "HttpServerAt_8771", HostPort: 8771, ImageDigest: "sha256:01", CreatedAt: createdAt, RunID: "run"},
+	}}
+	if err := Save(filename, saved); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// Overwriting replaces the previous state.
+	if err := Save(filename, saved); err != nil {
+		t.Fatalf("Save() twice error = %v", err)
+	}
+
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Version != Version || loaded.TlexVersion != TlexVersion || loaded.RunID != "run" {
+		t.Errorf("Load() = version %d, tlex version %q, run id %q", loaded.Version, loaded.TlexVersion, loaded.RunID)
+	}
+	if len(loaded.Containers) != 2 || loaded.Containers[1] != saved.Containers[1] {
+		t.Errorf("Load() containers = %v, want %v", loaded.Containers, saved.Containers)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Dir(filename))
+	if len(files) != 1 {
+		t.Errorf("Save() left %d files behind, want only the state file", len(files))
+	}
+
+	if err = Delete(filename); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err = Load(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() of a deleted state error = %v, want os.ErrNotExist", err)
+	}
+	if err = Delete(filename); err != nil {
+		t.Errorf("Delete() of a missing state error = %v", err)
+	}
+}
+
+func Test_Load_Migrates_Gob_File(t *testing.T) {
+
+	filename, cleanup := tempStateFilename(t)
+	defer cleanup()
+
+	gobFilename := filepath.Join(filepath.Dir(filename), mapsi2disk.GobFilename)
+	mapToSave := map[string]int{"c3": 8770, "a1": 8771, "b2": 8772}
+	if err := mapsi2disk.SaveContainerPorts2Disk(gobFilename, &mapToSave); err != nil {
+		t.Fatalf("SaveContainerPorts2Disk() error = %v", err)
+	}
+
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Containers) != 3 {
+		t.Fatalf("Load() migrated %d containers, want 3", len(loaded.Containers))
+	}
+	for i, cont := range loaded.Containers {
+		if mapToSave[cont.ID] != cont.HostPort {
+			t.Errorf("Migrated container %s at host port %d, want %d", cont.ID, cont.HostPort, mapToSave[cont.ID])
+		}
+		if i > 0 && loaded.Containers[i-1].HostPort > cont.HostPort {
+			t.Errorf("Migrated container %s out of host port order", cont.ID)
+		}
+	}
+	if _, err = os.Stat(gobFilename); !os.IsNotExist(err) {
+		t.Errorf("Load() left the migrated gob file behind: %v", err)
+	}
+	if _, err = read(filename); err != nil {
+		t.Errorf("Load() did not write the migrated state file: %v", err)
+	}
+}
+
+func Test_Load_Rejects_Corrupt_And_Newer_Files(t *testing.T) {
+
+	filename, cleanup := tempStateFilename(t)
+	defer cleanup()
+
+	ioutil.WriteFile(filename, []byte(`{"version": 1, "containers": [`), 0666)
+	if _, err := Load(filename); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Load() of a truncated file error = %v, want corrupt state file", err)
+	}
+
+	ioutil.WriteFile(filename, []byte(`{"version": 99, "containers": []}`), 0666)
+	if _, err := Load(filename); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Load() of a newer version error = %v, want a newer version error", err)
+	}
+}
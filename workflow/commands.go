@@ -0,0 +1,233 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/logger"
+
+	"github.com/oklog/run"
+)
+
+// The subcommands below split Workflow into separately scriptable fleet lifecycle steps.
+// up persists the owned containers to the state file, the rest act on that state.
+
+// Run removes any previous launch left overs and runs the Workflow from building to teardown.
+func Run(ctx context.Context, cfg config.AppConfig) error {
+
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	err = removePreviousLaunch(ctx, cfg, dockerClient, cfg.RemoveStaleContainers)
+	dockerClient.Close()
+	if err != nil {
+		return err
+	}
+
+	return Workflow(ctx, cfg)
+}
+
+// Build builds the Docker image only.
+func Build(ctx context.Context, cfg config.AppConfig) error {
+
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	return dockerapi.BuildDockerImage(ctx, dockerClient, cfg.DockerFilename, cfg.DockerImageName, time.Duration(cfg.BuildTimeout))
+}
+
+// Up removes any previous launch left overs, launches the requested live containers and
+// returns leaving them live once healthy. Unless all turn healthy, all the launched containers are removed.
+func Up(ctx context.Context, cfg config.AppConfig) error {
+
+	dumpConfig(cfg)
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	if err = removePreviousLaunch(ctx, cfg, dockerClient, cfg.RemoveStaleContainers); err != nil {
+		return err
+	}
+
+	owner := fleetOwner(cfg, dockerapi.NewRunID())
+	ownedContainers, err := launchContainers(ctx, cfg, dockerClient, owner)
+	if err != nil {
+		return err
+	}
+
+	probeCtx, stopProbes := context.WithCancel(ctx)
+	defer stopProbes()
+	if err = startHealthChecks(probeCtx, cfg, ownedContainers); err != nil {
+		removeContainers(cfg, owner, dockerClient)
+		return err
+	}
+
+	log.Printf("%d containers are live. Run tlex down to stop them.\n", len(ownedContainers))
+
+	return nil
+}
+
+// Down stops the owned live containers of a previous up and deletes the state file once they are.
+// The containers are found by the run ids recorded in the state file.
+func Down(ctx context.Context, cfg config.AppConfig) error {
+
+	if _, err := loadOwnedContainers(); err != nil {
+		return err
+	}
+
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	return removePreviousLaunch(ctx, cfg, dockerClient, true)
+}
+
+// Status lists the owned containers of a previous up with their Docker state and,
+// unless the health checks are disabled, the health of the running ones probed once.
+func Status(ctx context.Context, cfg config.AppConfig) error {
+
+	ownedContainers, err := loadOwnedContainers()
+	if err != nil {
+		return err
+	}
+
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	containers, err := ownedContainers.ListOwnedContainers(ctx, dockerClient)
+	if err != nil {
+		return err
+	}
+
+	probed := []int{}
+	for _, container := range containers {
+		if container.State == cfg.ContainerRunningStateString {
+			probed = append(probed, ownedContainers[container.ID])
+		}
+	}
+	probeErrs := map[int]error{}
+	if cfg.HealthCheckPath != "" {
+		probeErrs = probeFleet(ctx, cfg, &http.Client{Timeout: time.Duration(cfg.HealthCheckTimeout)}, probed)
+	}
+	health := func(hostPort int) string {
+		err, ok := probeErrs[hostPort]
+		switch {
+		case !ok:
+			return "-"
+		case err != nil:
+			return healthUnhealthy
+		}
+		return healthHealthy
+	}
+
+	listed := make(map[string]bool, len(containers))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER ID\tHOST PORT\tSTATE\tSTATUS\tHEALTH")
+	for _, container := range containers {
+		listed[container.ID] = true
+		hostPort := ownedContainers[container.ID]
+		fmt.Fprintf(tw, "%.12s\t%d\t%s\t%s\t%s\n", container.ID, hostPort, container.State, container.Status, health(hostPort))
+	}
+	for containerID, hostPort := range ownedContainers {
+		if !listed[containerID] {
+			fmt.Fprintf(tw, "%.12s\t%d\t%s\t%s\t%s\n", containerID, hostPort, "gone", "", "-")
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	sort.Ints(probed)
+	for _, hostPort := range probed {
+		if err := probeErrs[hostPort]; err != nil {
+			fmt.Printf("Container at port %d is unhealthy: %v\n", hostPort, err)
+		}
+	}
+
+	return nil
+}
+
+// Logs attaches to the owned live containers' log streams until interrupted.
+// Unlike Workflow, interrupting leaves the containers live.
+func Logs(ctx context.Context, cfg config.AppConfig) error {
+
+	return attach(ctx, cfg, openContainersLogger, func(ctx context.Context, containersLogger logger.Logger, dockerClient dockerapi.ContainerEngine, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers) error {
+		return aggContainersLogStreams(ctx, containersLogger, dockerClient, cfg, g, ownedContainers, nil)
+	})
+}
+
+// Stats attaches to the owned live containers' stats streams until interrupted
+// and serves them at the /metrics endpoint when configured.
+// Unlike Workflow, interrupting leaves the containers live.
+func Stats(ctx context.Context, cfg config.AppConfig) error {
+
+	return attach(ctx, cfg, openStatsLogger, func(ctx context.Context, statsLogger logger.Logger, dockerClient dockerapi.ContainerEngine, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers) error {
+		fleet := metricsFor(cfg, len(ownedContainers))
+		if err := monitorContainerStatStreams(ctx, statsLogger, dockerClient, cfg, g, ownedContainers, fleet, nil); err != nil || fleet == nil {
+			return err
+		}
+
+		_, err := serveMetrics(cfg.MetricsAddress, g, fleet)
+		return err
+	})
+}
+
+// attach runs the streams monitor for the owned containers of a previous up logging to openLogger.
+func attach(ctx context.Context, cfg config.AppConfig, openLogger func(config.AppConfig) (logger.Logger, error), monitor func(context.Context, logger.Logger, dockerapi.ContainerEngine, config.AppConfig, *run.Group, dockerapi.OwnedContainers) error) error {
+
+	ownedContainers, err := loadOwnedContainers()
+	if err != nil {
+		return err
+	}
+
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
+	defer dockerClient.Close()
+
+	streamsLogger, err := openLogger(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeLogger(streamsLogger)
+
+	var attachGroup run.Group
+	if err = monitor(ctx, streamsLogger, dockerClient, cfg, &attachGroup, ownedContainers); err != nil {
+		return err
+	}
+	awaitTerminateSignal(ctx, &attachGroup, len(ownedContainers))
+
+	return attachGroup.Run()
+}
+
+// loadOwnedContainers reads the state file of a previous up.
+func loadOwnedContainers() (dockerapi.OwnedContainers, error) {
+
+	ownedContainers, err := dockerapi.LoadOwnedContainers()
+	if err != nil {
+		return nil, fmt.Errorf("no owned containers found, run tlex up first: %v", err)
+	}
+
+	return ownedContainers, nil
+}
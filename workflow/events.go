@@ -0,0 +1,104 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/logger"
+
+	"github.com/oklog/run"
+)
+
+// watchContainerEvents adds an actor to g logging the lifecycle events of the owner's containers
+// to eventsLogger in cfg.LogFormat and feeding them to the healer until interrupted.
+// A failed subscription, e.g. after a daemon restart, is renewed past its last event after a backoff.
+func watchContainerEvents(ctx context.Context, eventsLogger logger.Logger, dockerClient dockerapi.ContainerEngine, cfg config.AppConfig, g *run.Group, owner dockerapi.Owner, healer *fleetHealer) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	g.Add(func() error {
+
+		retry := newBackoff(time.Duration(cfg.StreamReattachBackoff), time.Duration(cfg.StreamReattachMaxBackoff))
+		since := time.Now()
+		for {
+			containerEvents, errs := owner.SubscribeEvents(ctx, dockerClient, since)
+			subscribedAt := time.Now()
+
+			var err error
+			for err == nil {
+				select {
+				case event := <-containerEvents:
+					// The daemon replays the events at since too.
+					since = event.Time.Add(time.Nanosecond)
+					eventsLogger.Log(eventLevel(event), formatContainerEvent(cfg.LogFormat, event))
+					healer.observe(event)
+				case err = <-errs:
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if time.Since(subscribedAt) >= retry.max {
+				retry.reset()
+			}
+			wait := retry.wait()
+			log.Printf("The containers events subscription failed: %v. Subscribing again in %v.\n", err, wait)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+		}
+
+	}, func(error) {
+
+		cancel()
+
+	})
+}
+
+// eventLevel returns warn for the events of a failing container and info for the rest.
+func eventLevel(event dockerapi.ContainerEvent) logger.Level {
+
+	switch {
+	case event.Action == dockerapi.EventOOM,
+		event.Action == dockerapi.EventDie && event.ExitCode != nil && *event.ExitCode != 0,
+		event.Action == dockerapi.EventHealthStatus && event.Health == "unhealthy":
+		return logger.LevelWarn
+	}
+
+	return logger.LevelInfo
+}
+
+// formatContainerEvent renders a container event in format: a text line, the container log records alike,
+// or a JSON object.
+func formatContainerEvent(format string, event dockerapi.ContainerEvent) string {
+
+	if format == config.LogFormatJSON {
+		eventLine, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Sprintf("{\"containerId\":%q,\"error\":%q}", event.ContainerID, err.Error())
+		}
+		return string(eventLine)
+	}
+
+	details := []string{}
+	if event.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exitCode=%d", *event.ExitCode))
+	}
+	if event.Signal != "" {
+		details = append(details, "signal="+event.Signal)
+	}
+	if event.Health != "" {
+		details = append(details, "health="+event.Health)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s @ port %d %s %.12s %s %s", event.Time.UTC().Format(logTimeLayout), event.HostPort, event.ContainerName, event.ContainerID, event.Action, strings.Join(details, " ")))
+}
@@ -0,0 +1,95 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/logger"
+	"tlex/state"
+
+	"github.com/oklog/run"
+)
+
+func Test_Container_Events_Wake_The_Healer_Fake_Engine(t *testing.T) {
+
+	fake, cfg, owned, byPort := superviseFleet(t)
+	cfg.DockerImageName = "echo:latest"
+	cfg.RestartBackoff = config.Duration(10 * time.Millisecond)
+	// Only the die event triggers the relaunch.
+	cfg.ReconcileInterval = config.Duration(time.Hour)
+	defer state.Delete(state.Filename)
+
+	sink := &recordingSink{}
+	eventsLogger := logger.NewLogger(logger.Options{}, sink)
+	defer eventsLogger.Close()
+	healer := newFleetHealer(cfg, fake, "run", owned)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g run.Group
+	healer.reconcile(ctx, &g)
+	watchContainerEvents(ctx, eventsLogger, fake, cfg, &g, fleetOwner(cfg, "run"), healer)
+	_, stop := runGroup(t, &g)
+	defer stop()
+	defer cancel()
+
+	// The subscription starts with the actor.
+	time.Sleep(20 * time.Millisecond)
+	if err := fake.Crash(byPort[8770], 137, true); err != nil {
+		t.Fatalf("Crash() error = %v", err)
+	}
+	await(t, "the relaunched container", func() bool {
+		return healer.restartsAt(8770) == 1
+	})
+	await(t, "the logged events", func() bool {
+		return sink.count("HttpServerAt_8770") == 3
+	})
+	for _, want := range []string{" oom", " die exitCode=137", " start"} {
+		if sink.count(want) != 1 {
+			t.Errorf("Events log %q lines = %d, want 1", want, sink.count(want))
+		}
+	}
+
+	// A renewed subscription resumes after the last event.
+	fake.DropEvents()
+	if err := fake.ContainerStop(ctx, byPort[8771], nil); err != nil {
+		t.Fatalf("ContainerStop() error = %v", err)
+	}
+	await(t, "the events after the renewed subscription", func() bool {
+		return sink.count("HttpServerAt_8771 ") == 3 && sink.count(" start") == 2
+	})
+	if n := sink.count(" oom"); n != 1 {
+		t.Errorf("Events log oom lines = %d after the renewed subscription, want 1", n)
+	}
+}
+
+func Test_Container_Event_Log_Formats(t *testing.T) {
+
+	exitCode := 137
+	event := dockerapi.ContainerEvent{
+		Time:          time.Date(2026, 1, 2, 3, 4, 5, 500000000, time.UTC),
+		Action:        dockerapi.EventDie,
+		ContainerID:   "0123456789abcdef",
+		ContainerName: "HttpServerAt_8770",
+		HostPort:      8770,
+		ExitCode:      &exitCode,
+	}
+
+	if text, want := formatContainerEvent(config.LogFormatText, event), "2026-01-02T03:04:05.500000000Z @ port 8770 HttpServerAt_8770 0123456789ab die exitCode=137"; text != want {
+		t.Errorf("formatContainerEvent(text) = %q, want %q", text, want)
+	}
+	if jsonLine := formatContainerEvent(config.LogFormatJSON, event); !strings.Contains(jsonLine, `"action":"die"`) || !strings.Contains(jsonLine, `"exitCode":137`) || strings.Contains(jsonLine, "health") {
+		t.Errorf("formatContainerEvent(json) = %s", jsonLine)
+	}
+	if level := eventLevel(event); level != logger.LevelWarn {
+		t.Errorf("eventLevel(die 137) = %v, want warn", level)
+	}
+	exitCode = 0
+	if level := eventLevel(event); level != logger.LevelInfo {
+		t.Errorf("eventLevel(die 0) = %v, want info", level)
+	}
+}
@@ -0,0 +1,278 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+)
+
+// Health of a container's echo endpoint.
+const (
+	healthStarting  = "starting"
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+)
+
+// maxEchoSize bounds the echoed body read by a probe.
+const maxEchoSize = 64 * 1024
+
+// containerHealth is the probes' verdict on a host port.
+type containerHealth struct {
+	status string
+	// successes and failures count the consecutive probes passing or failing.
+	successes int
+	failures  int
+	lastErr   error
+}
+
+// fleetHealth probes the echo endpoint of the containers at every host port, see probeEcho.
+// A container turns healthy after HealthCheckSuccessThreshold passing probes in a row
+// and unhealthy after HealthCheckFailureThreshold failing ones.
+type fleetHealth struct {
+	cfg    config.AppConfig
+	client *http.Client
+
+	mutex  sync.Mutex
+	health map[int]*containerHealth
+	// changed is closed and renewed when a host port's status changes.
+	changed chan struct{}
+}
+
+// newFleetHealth returns the health of the owned containers' host ports, all starting,
+// or nil when the health checks are disabled.
+func newFleetHealth(cfg config.AppConfig, ownedContainers dockerapi.OwnedContainers) *fleetHealth {
+
+	if cfg.HealthCheckPath == "" {
+		return nil
+	}
+
+	health := make(map[int]*containerHealth, len(ownedContainers))
+	for _, hostPort := range ownedContainers {
+		health[hostPort] = &containerHealth{status: healthStarting}
+	}
+
+	return &fleetHealth{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: time.Duration(cfg.HealthCheckTimeout)},
+		health:  health,
+		changed: make(chan struct{}),
+	}
+}
+
+// startHealthChecks probes the owned containers until ctx is done, logging their health changes,
+// and waits for them to turn healthy. It returns the awaitHealthy error.
+func startHealthChecks(ctx context.Context, cfg config.AppConfig, ownedContainers dockerapi.OwnedContainers) error {
+
+	health := newFleetHealth(cfg, ownedContainers)
+	if health == nil {
+		return nil
+	}
+
+	go health.probeLoop(ctx)
+
+	return health.awaitHealthy(ctx)
+}
+
+// probeLoop probes every host port each HealthCheckInterval until ctx is done.
+func (health *fleetHealth) probeLoop(ctx context.Context) {
+
+	ticker := time.NewTicker(time.Duration(health.cfg.HealthCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		for hostPort, err := range probeFleet(ctx, health.cfg, health.client, health.hostPorts()) {
+			if ctx.Err() != nil {
+				return
+			}
+			health.record(hostPort, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// hostPorts lists the probed host ports in order.
+func (health *fleetHealth) hostPorts() []int {
+
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	hostPorts := make([]int, 0, len(health.health))
+	for hostPort := range health.health {
+		hostPorts = append(hostPorts, hostPort)
+	}
+	sort.Ints(hostPorts)
+
+	return hostPorts
+}
+
+// record counts a probe of hostPort and reports its status changes.
+func (health *fleetHealth) record(hostPort int, err error) {
+
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	container := health.health[hostPort]
+	status := container.status
+	if err == nil {
+		container.successes++
+		container.failures = 0
+		if container.successes >= health.cfg.HealthCheckSuccessThreshold {
+			status = healthHealthy
+		}
+	} else {
+		container.failures++
+		container.successes = 0
+		container.lastErr = err
+		if container.failures >= health.cfg.HealthCheckFailureThreshold {
+			status = healthUnhealthy
+		}
+	}
+	if status == container.status {
+		return
+	}
+
+	switch {
+	case status == healthHealthy:
+		log.Printf("Container at port %d is healthy.\n", hostPort)
+	case container.status == healthHealthy:
+		log.Printf("Container at port %d is unhealthy after %d failed probes: %v\n", hostPort, container.failures, err)
+	}
+	container.status = status
+	close(health.changed)
+	health.changed = make(chan struct{})
+}
+
+// status returns the status of hostPort and its last probe error.
+func (health *fleetHealth) status(hostPort int) (string, error) {
+
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	container, ok := health.health[hostPort]
+	if !ok {
+		return "", nil
+	}
+
+	return container.status, container.lastErr
+}
+
+// awaitHealthy waits for every host port to turn healthy, bounded by HealthCheckStartTimeout unless 0.
+// Upon timeout it returns a *dockerapi.ContainerError wrapping dockerapi.ErrContainerUnhealthy
+// of the first host port not healthy.
+func (health *fleetHealth) awaitHealthy(ctx context.Context) error {
+
+	if health == nil {
+		return nil
+	}
+
+	ctx, cancel := dockerapi.WithTimeout(ctx, time.Duration(health.cfg.HealthCheckStartTimeout))
+	defer cancel()
+
+	for {
+		health.mutex.Lock()
+		changed := health.changed
+		health.mutex.Unlock()
+
+		notHealthy := 0
+		var firstErr error
+		for _, hostPort := range health.hostPorts() {
+			status, lastErr := health.status(hostPort)
+			if status == healthHealthy {
+				continue
+			}
+			notHealthy++
+			if firstErr == nil {
+				firstErr = &dockerapi.ContainerError{Op: "health check", HostPort: hostPort, Err: fmt.Errorf("%w: %s, last probe: %v", dockerapi.ErrContainerUnhealthy, status, lastErr)}
+			}
+		}
+		if notHealthy == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			log.Printf("%d containers are not healthy after %v.\n", notHealthy, health.cfg.HealthCheckStartTimeout)
+			return firstErr
+		}
+	}
+}
+
+// probeFleet probes the echo endpoint of hostPorts concurrently and returns their errors by host port.
+func probeFleet(ctx context.Context, cfg config.AppConfig, client *http.Client, hostPorts []int) map[int]error {
+
+	errs := make(map[int]error, len(hostPorts))
+	var mutex sync.Mutex
+	var probes sync.WaitGroup
+	for _, hostPort := range hostPorts {
+		probes.Add(1)
+		go func(hostPort int) {
+			defer probes.Done()
+			err := probeEcho(ctx, client, cfg.HealthCheckHost, hostPort, cfg.HealthCheckPath)
+			mutex.Lock()
+			errs[hostPort] = err
+			mutex.Unlock()
+		}(hostPort)
+	}
+	probes.Wait()
+
+	return errs
+}
+
+// probeEcho GETs path at host:hostPort and checks the echo server answers it with exactly the path
+// without its leading slash, the way http://host:8770/1/2/3/ answers 1/2/3/.
+func probeEcho(ctx context.Context, client *http.Client, host string, hostPort int, path string) error {
+
+	path = "/" + strings.TrimPrefix(path, "/")
+	echo, err := fetchEcho(ctx, client, fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(hostPort)), path))
+	if err != nil {
+		return err
+	}
+	if want := strings.TrimPrefix(path, "/"); strings.TrimRight(echo, "\r\n") != want {
+		return fmt.Errorf("echoed %q instead of %q", echo, want)
+	}
+
+	return nil
+}
+
+// fetchEcho GETs url and returns the body of an OK response, up to maxEchoSize bytes.
+func fetchEcho(ctx context.Context, client *http.Client, url string) (string, error) {
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxEchoSize))
+	if err != nil {
+		return "", fmt.Errorf("while reading %s: %w", url, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", url, response.Status)
+	}
+
+	return string(body), nil
+}
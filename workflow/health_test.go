@@ -0,0 +1,122 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/internal/fakeengine"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// healthConfig probes every 10ms turning a container healthy or unhealthy after 2 probes.
+func healthConfig() config.AppConfig {
+
+	cfg := config.GetConfig()
+	cfg.HealthCheckHost = "127.0.0.1"
+	cfg.HealthCheckInterval = config.Duration(10 * time.Millisecond)
+	cfg.HealthCheckTimeout = config.Duration(time.Second)
+	cfg.HealthCheckSuccessThreshold = 2
+	cfg.HealthCheckFailureThreshold = 2
+	cfg.HealthCheckStartTimeout = config.Duration(5 * time.Second)
+
+	return cfg
+}
+
+func Test_Health_Checks_Fake_Engine(t *testing.T) {
+
+	fake := fakeengine.New()
+	fake.Echo = true
+
+	var launcherGroup errgroup.Group
+	owned := make(dockerapi.OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, dockerapi.NewPortAllocator(8790, 0, nil), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+	defer dockerapi.Owner{RunID: "run"}.CleanLeftOverContainers(context.Background(), fake, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	health := newFleetHealth(healthConfig(), owned)
+	go health.probeLoop(ctx)
+	if err := health.awaitHealthy(ctx); err != nil {
+		t.Fatalf("awaitHealthy() error = %v", err)
+	}
+	for _, hostPort := range []int{8790, 8791} {
+		if status, _ := health.status(hostPort); status != healthHealthy {
+			t.Errorf("Container at port %d is %s, want healthy", hostPort, status)
+		}
+	}
+
+	for containerID, hostPort := range owned {
+		if hostPort == 8791 {
+			fake.ContainerStop(ctx, containerID, nil)
+		}
+	}
+	await(t, "the stopped container to turn unhealthy", func() bool {
+		status, _ := health.status(8791)
+		return status == healthUnhealthy
+	})
+	if status, _ := health.status(8790); status != healthHealthy {
+		t.Errorf("Container at port 8790 is %s, want healthy", status)
+	}
+}
+
+func Test_Health_Checks_Gate_Times_Out_Fake_Engine(t *testing.T) {
+
+	// A port nothing listens to.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	hostPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cfg := healthConfig()
+	cfg.HealthCheckStartTimeout = config.Duration(100 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = startHealthChecks(ctx, cfg, dockerapi.OwnedContainers{"dead": hostPort})
+	var containerErr *dockerapi.ContainerError
+	if !errors.Is(err, dockerapi.ErrContainerUnhealthy) || !errors.As(err, &containerErr) || containerErr.HostPort != hostPort {
+		t.Errorf("startHealthChecks() error = %v, want ErrContainerUnhealthy at port %d", err, hostPort)
+	}
+}
+
+func Test_Health_Probe_Expects_The_Echoed_Path(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing/" {
+			http.NotFound(w, r)
+			return
+		}
+		// The echo of a longer path contains the probed one.
+		io.WriteString(w, strings.TrimPrefix(r.URL.Path, "/")+"tlex/health/")
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	hostPort, _ := strconv.Atoi(port)
+
+	if err := probeEcho(context.Background(), server.Client(), host, hostPort, "/tlex/health/"); err == nil {
+		t.Errorf("probeEcho() of a wrong echo error = nil")
+	}
+	if err := probeEcho(context.Background(), server.Client(), host, hostPort, "missing"); err == nil {
+		t.Errorf("probeEcho() of a 404 error = nil")
+	}
+	if err := probeEcho(context.Background(), server.Client(), host, hostPort, "/"); err == nil {
+		t.Errorf("probeEcho() of an echo containing the path error = nil")
+	}
+}
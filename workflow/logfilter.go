@@ -0,0 +1,119 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"tlex/config"
+	"tlex/logger"
+)
+
+// levelPattern finds the first level word of a log line, as in "ERROR ...", "[warn]", "level=info" or "\"level\":\"debug\"".
+var levelPattern = regexp.MustCompile(`(?i)\b(fatal|panic|crit(?:ical)?|err(?:or)?|warn(?:ing)?|info|debug|trace)\b`)
+
+// logFilter selects log lines by their message.
+type logFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// logFilters holds the fleet wide filter and the per container filters by host port.
+type logFilters struct {
+	fleet      logFilter
+	containers map[int]logFilter
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+func compileLogFilter(filter config.LogFilter) (logFilter, error) {
+
+	include, err := compilePatterns(filter.Include)
+	if err != nil {
+		return logFilter{}, err
+	}
+	exclude, err := compilePatterns(filter.Exclude)
+	if err != nil {
+		return logFilter{}, err
+	}
+
+	return logFilter{include: include, exclude: exclude}, nil
+}
+
+// newLogFilters compiles the validated cfg log filters.
+func newLogFilters(cfg config.AppConfig) (*logFilters, error) {
+
+	fleet, err := compileLogFilter(cfg.LogFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := &logFilters{fleet: fleet, containers: make(map[int]logFilter, len(cfg.ContainerLogFilters))}
+	for key, containerFilter := range cfg.ContainerLogFilters {
+		hostPort, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, err
+		}
+		if filters.containers[hostPort], err = compileLogFilter(containerFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	return filters, nil
+}
+
+// allows tells whether message matches one of the include patterns, if any, and none of the exclude ones.
+func (filter logFilter) allows(message string) bool {
+
+	for _, re := range filter.exclude {
+		if re.MatchString(message) {
+			return false
+		}
+	}
+	for _, re := range filter.include {
+		if re.MatchString(message) {
+			return true
+		}
+	}
+
+	return len(filter.include) == 0
+}
+
+// allows tells whether the message of the container at hostPort passes both the fleet and its container filter.
+func (filters *logFilters) allows(hostPort int, message string) bool {
+
+	return filters.fleet.allows(message) && filters.containers[hostPort].allows(message)
+}
+
+// detectLevel returns the level named first in message.
+func detectLevel(message string) (logger.Level, bool) {
+
+	match := levelPattern.FindString(message)
+	if match == "" {
+		return logger.LevelInfo, false
+	}
+
+	switch word := strings.ToLower(match); {
+	case strings.HasPrefix(word, "warn"):
+		return logger.LevelWarn, true
+	case word == "info":
+		return logger.LevelInfo, true
+	case word == "debug" || word == "trace":
+		return logger.LevelDebug, true
+	}
+
+	return logger.LevelError, true
+}
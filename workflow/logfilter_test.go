@@ -0,0 +1,61 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"testing"
+
+	"tlex/config"
+	"tlex/logger"
+)
+
+func Test_Log_Filters_Fleet_And_Per_Container(t *testing.T) {
+
+	cfg := config.GetConfig()
+	cfg.LogFilter = config.LogFilter{Exclude: []string{`GET /health`}}
+	cfg.ContainerLogFilters = map[string]config.LogFilter{"8771": {Include: []string{`(?i)error`, `^GET /1/`}}}
+
+	filters, err := newLogFilters(cfg)
+	if err != nil {
+		t.Fatalf("newLogFilters() error = %v", err)
+	}
+
+	tests := []struct {
+		hostPort int
+		message  string
+		want     bool
+	}{
+		{8770, "GET /1/2/3/", true},
+		{8770, "GET /health", false},
+		{8771, "GET /1/2/3/", true},
+		{8771, "GET /4/", false},
+		{8771, "Error: listen tcp :8770: bind", true},
+		{8771, "GET /health Error", false},
+	}
+	for _, tt := range tests {
+		if got := filters.allows(tt.hostPort, tt.message); got != tt.want {
+			t.Errorf("allows(%d, %q) = %v, want %v", tt.hostPort, tt.message, got, tt.want)
+		}
+	}
+}
+
+func Test_Log_Level_Detection(t *testing.T) {
+
+	tests := []struct {
+		message string
+		level   logger.Level
+		found   bool
+	}{
+		{"2020/01/02 03:04:05 ERROR listen tcp :8770: bind: address already in use", logger.LevelError, true},
+		{`{"level":"warn","msg":"slow request"}`, logger.LevelWarn, true},
+		{"level=debug msg=echo", logger.LevelDebug, true},
+		{"[INFO] serving on :8770", logger.LevelInfo, true},
+		{"panic: runtime error", logger.LevelError, true},
+		{"GET /1/2/3/", logger.LevelInfo, false},
+		{"GET /information/", logger.LevelInfo, false},
+	}
+	for _, tt := range tests {
+		if level, found := detectLevel(tt.message); level != tt.level || found != tt.found {
+			t.Errorf("detectLevel(%q) = %v, %v, want %v, %v", tt.message, level, found, tt.level, tt.found)
+		}
+	}
+}
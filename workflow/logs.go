@@ -0,0 +1,176 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/logger"
+)
+
+// logTimeLayout is a fixed width RFC 3339 layout so text log records sort by time.
+const logTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// LogRecord is a line of a container's output with its origin.
+// It is written as a JSON object per line in the json log format.
+type LogRecord struct {
+	// Timestamp is the daemon time of the line, or ReceivedAt for a line without one.
+	Timestamp     time.Time        `json:"timestamp"`
+	ReceivedAt    time.Time        `json:"receivedAt"`
+	ContainerID   string           `json:"containerId"`
+	ContainerName string           `json:"containerName"`
+	HostPort      int              `json:"hostPort"`
+	Stream        dockerapi.Stream `json:"stream"`
+	// Level is the level detected in Message, if enabled.
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message"`
+}
+
+// newLogRecord returns the record of a timestamped line of the logs stream received at receivedAt.
+func newLogRecord(logs dockerapi.ContainerReaderStream, line dockerapi.LogLine, receivedAt time.Time) LogRecord {
+
+	timestamp, message, ok := dockerapi.SplitLogTimestamp(line.Text)
+	if !ok {
+		timestamp = receivedAt
+	}
+
+	return LogRecord{
+		Timestamp:     timestamp.UTC(),
+		ReceivedAt:    receivedAt.UTC(),
+		ContainerID:   logs.ContainerID,
+		ContainerName: logs.ContainerName,
+		HostPort:      logs.HostPort,
+		Stream:        line.Stream,
+		Message:       message,
+	}
+}
+
+// formatLogRecord renders record as a text line or a JSON object per logFormat.
+func formatLogRecord(logFormat string, record LogRecord) string {
+
+	if logFormat != config.LogFormatJSON {
+		return fmt.Sprintf("%s @ port %d %s %s: %s", record.Timestamp.Format(logTimeLayout), record.HostPort, record.ContainerName, record.Stream, record.Message)
+	}
+
+	logLine, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprintf("{\"hostPort\":%d,\"error\":%q}", record.HostPort, err.Error())
+	}
+
+	return string(logLine)
+}
+
+// loggerOptions returns the buffering options of the log and stats loggers.
+func loggerOptions(cfg config.AppConfig) logger.Options {
+
+	return logger.Options{
+		BufferSize:    cfg.LogBufferSize,
+		FlushInterval: time.Duration(cfg.LogFlushInterval),
+	}
+}
+
+// loggerRotation returns the logger rotation of a configured log file rotation.
+func loggerRotation(rotation config.Rotation) logger.Rotation {
+
+	return logger.Rotation{
+		MaxSize:  int64(rotation.MaxSizeMB) * 1024 * 1024,
+		MaxAge:   time.Duration(rotation.MaxAge),
+		MaxFiles: rotation.MaxFiles,
+		Compress: rotation.Compress,
+	}
+}
+
+// openContainersLogger returns the logger of the container log lines writing to every sink of cfg.LogSinks
+// the lines of at least cfg.LogStdoutLevel for stdout and cfg.LogPersistLevel for the rest.
+func openContainersLogger(cfg config.AppConfig) (logger.Logger, error) {
+
+	stdoutLevel, err := logger.ParseLevel(cfg.LogStdoutLevel)
+	if err != nil {
+		return logger.Logger{}, err
+	}
+	persistLevel, err := logger.ParseLevel(cfg.LogPersistLevel)
+	if err != nil {
+		return logger.Logger{}, err
+	}
+
+	sinks := []logger.Sink{}
+	for _, spec := range cfg.LogSinks {
+		sink, err := openLogSink(cfg, spec)
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return logger.Logger{}, fmt.Errorf("unable to open log sink %s: %w", spec, err)
+		}
+		if spec == config.LogSinkStdout {
+			sink = logger.MinLevel(sink, stdoutLevel)
+		} else {
+			sink = logger.MinLevel(sink, persistLevel)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return logger.NewLogger(loggerOptions(cfg), sinks...), nil
+}
+
+// openLogSink returns the sink of a validated config.AppConfig LogSinks entry.
+func openLogSink(cfg config.AppConfig, spec string) (logger.Sink, error) {
+
+	switch spec {
+	case config.LogSinkStdout:
+		return logger.NewStdoutSink(), nil
+	case config.LogSinkFile:
+		return logger.OpenFileSink(cfg.LogFilename, loggerRotation(cfg.LogRotation))
+	}
+
+	sinkURL, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch sinkURL.Scheme {
+	case "syslog+udp", "syslog+tcp":
+		return logger.NewSyslogSink(strings.TrimPrefix(sinkURL.Scheme, "syslog+"), sinkURL.Host, "tlex"), nil
+	case "tcp":
+		return logger.NewTCPSink(sinkURL.Host), nil
+	case "http", "https":
+		return logger.NewHTTPSink(spec, cfg.LogHTTPBatchSize), nil
+	}
+
+	return nil, fmt.Errorf("unknown log sink scheme %s", sinkURL.Scheme)
+}
+
+// openStatsLogger returns the logger of the stats file.
+func openStatsLogger(cfg config.AppConfig) (logger.Logger, error) {
+
+	sink, err := logger.OpenFileSink(cfg.StatsFilename, loggerRotation(cfg.StatsRotation))
+	if err != nil {
+		return logger.Logger{}, err
+	}
+
+	return logger.NewLogger(loggerOptions(cfg), sink), nil
+}
+
+// openEventsLogger returns the logger of the events file, rotated like the log file.
+func openEventsLogger(cfg config.AppConfig) (logger.Logger, error) {
+
+	sink, err := logger.OpenFileSink(cfg.EventsFilename, loggerRotation(cfg.LogRotation))
+	if err != nil {
+		return logger.Logger{}, err
+	}
+
+	return logger.NewLogger(loggerOptions(cfg), sink), nil
+}
+
+// closeLogger closes a log file reporting its dropped or failed lines.
+func closeLogger(fileLogger logger.Logger) {
+
+	if err := fileLogger.Close(); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
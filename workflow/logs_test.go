@@ -0,0 +1,68 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+)
+
+func Test_Log_Record_Has_Daemon_Timestamp_And_Origin(t *testing.T) {
+
+	logs := dockerapi.ContainerReaderStream{HostPort: 8770, ContainerID: "c0ffee", ContainerName: "HttpServerAt_8770"}
+	receivedAt := time.Date(2026, 1, 2, 3, 4, 6, 0, time.UTC)
+	line := dockerapi.LogLine{Stream: dockerapi.StreamStderr, Text: "2026-01-02T03:04:05.5Z listening on :8770"}
+
+	var record LogRecord
+	if err := json.Unmarshal([]byte(formatLogRecord(config.LogFormatJSON, newLogRecord(logs, line, receivedAt))), &record); err != nil {
+		t.Fatalf("json log record does not parse: %v", err)
+	}
+
+	want := LogRecord{
+		Timestamp:     time.Date(2026, 1, 2, 3, 4, 5, 500000000, time.UTC),
+		ReceivedAt:    receivedAt,
+		ContainerID:   "c0ffee",
+		ContainerName: "HttpServerAt_8770",
+		HostPort:      8770,
+		Stream:        dockerapi.StreamStderr,
+		Message:       "listening on :8770",
+	}
+	if record != want {
+		t.Errorf("log record = %+v, want %+v", record, want)
+	}
+
+	text := formatLogRecord(config.GetConfig().LogFormat, want)
+	if wantText := "2026-01-02T03:04:05.500000000Z @ port 8770 HttpServerAt_8770 stderr: listening on :8770"; text != wantText {
+		t.Errorf("text log record = %q, want %q", text, wantText)
+	}
+
+	// A line without timestamp is kept whole at its receive time.
+	record = newLogRecord(logs, dockerapi.LogLine{Stream: dockerapi.StreamStdout, Text: "no timestamp"}, receivedAt)
+	if record.Message != "no timestamp" || !record.Timestamp.Equal(receivedAt) {
+		t.Errorf("log record of a line without timestamp = %+v", record)
+	}
+}
+
+func Test_Text_Log_Records_Sort_By_Time(t *testing.T) {
+
+	logs := dockerapi.ContainerReaderStream{HostPort: 8770, ContainerName: "HttpServerAt_8770"}
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	offsets := []time.Duration{time.Second, 0, 10 * time.Millisecond, time.Millisecond, 100 * time.Microsecond}
+
+	lines := make([]string, len(offsets))
+	for i, offset := range offsets {
+		lines[i] = formatLogRecord(config.LogFormatText, LogRecord{Timestamp: base.Add(offset), HostPort: logs.HostPort, ContainerName: logs.ContainerName, Stream: dockerapi.StreamStdout})
+	}
+	sort.Strings(lines)
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for i, offset := range offsets {
+		if want := base.Add(offset).Format(logTimeLayout); lines[i][:len(want)] != want {
+			t.Errorf("sorted line %d = %q, want time %s", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/oklog/run"
+)
+
+// fleetMetrics holds the latest stats record of every monitored container and
+// serves them in the Prometheus text exposition format.
+type fleetMetrics struct {
+	mutex     sync.Mutex
+	requested int
+	// records by container ID
+	records map[string]StatsRecord
+}
+
+// containerMetric is a per container metric exported from a StatsRecord.
+type containerMetric struct {
+	name       string
+	metricType string
+	help       string
+	value      func(StatsRecord) string
+}
+
+func floatValue(value func(StatsRecord) float64) func(StatsRecord) string {
+
+	return func(record StatsRecord) string {
+		return strconv.FormatFloat(value(record), 'g', -1, 64)
+	}
+}
+
+func uintValue(value func(StatsRecord) uint64) func(StatsRecord) string {
+
+	return func(record StatsRecord) string {
+		return strconv.FormatUint(value(record), 10)
+	}
+}
+
+var containerMetrics = []containerMetric{
+	{"tlex_container_cpu_percent", "gauge", "CPU usage percent of the host CPUs since the previous sample.",
+		floatValue(func(r StatsRecord) float64 { return r.CPUPercent })},
+	{"tlex_container_cpu_limit", "gauge", "Configured limit of CPUs, 0 for unlimited.",
+		floatValue(func(r StatsRecord) float64 { return r.CPULimit })},
+	{"tlex_container_memory_usage_bytes", "gauge", "Memory usage without the page cache.",
+		uintValue(func(r StatsRecord) uint64 { return r.MemoryUsageBytes })},
+	{"tlex_container_memory_limit_bytes", "gauge", "Memory limit.",
+		uintValue(func(r StatsRecord) uint64 { return r.MemoryLimitBytes })},
+	{"tlex_container_memory_percent", "gauge", "Memory usage percent of the limit.",
+		floatValue(func(r StatsRecord) float64 { return r.MemoryPercent })},
+	{"tlex_container_network_receive_bytes_total", "counter", "Bytes received across the network interfaces.",
+		uintValue(func(r StatsRecord) uint64 { return r.NetworkRxBytes })},
+	{"tlex_container_network_transmit_bytes_total", "counter", "Bytes transmitted across the network interfaces.",
+		uintValue(func(r StatsRecord) uint64 { return r.NetworkTxBytes })},
+	{"tlex_container_block_read_bytes_total", "counter", "Bytes read from the block devices.",
+		uintValue(func(r StatsRecord) uint64 { return r.BlockReadBytes })},
+	{"tlex_container_block_write_bytes_total", "counter", "Bytes written to the block devices.",
+		uintValue(func(r StatsRecord) uint64 { return r.BlockWriteBytes })},
+	{"tlex_container_pids", "gauge", "Number of processes.",
+		uintValue(func(r StatsRecord) uint64 { return r.PIDs })},
+	{"tlex_container_pids_limit", "gauge", "Configured limit of processes, 0 for unlimited.",
+		uintValue(func(r StatsRecord) uint64 { return r.PIDsLimit })},
+	{"tlex_container_restarts_total", "counter", "Relaunches of dead containers at the host port.",
+		uintValue(func(r StatsRecord) uint64 { return uint64(r.Restarts) })},
+}
+
+func newFleetMetrics(requested int) *fleetMetrics {
+
+	return &fleetMetrics{
+		requested: requested,
+		records:   make(map[string]StatsRecord),
+	}
+}
+
+// observe records the latest stats of a container.
+func (fleet *fleetMetrics) observe(record StatsRecord) {
+
+	fleet.mutex.Lock()
+	defer fleet.mutex.Unlock()
+
+	fleet.records[record.ContainerID] = record
+}
+
+// forget drops a container whose stats stream ended so it no longer counts as live.
+func (fleet *fleetMetrics) forget(containerID string) {
+
+	fleet.mutex.Lock()
+	defer fleet.mutex.Unlock()
+
+	delete(fleet.records, containerID)
+}
+
+// ServeHTTP writes the fleet and per container metrics in the Prometheus text format.
+func (fleet *fleetMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fleet.write(w)
+}
+
+func (fleet *fleetMetrics) write(w io.Writer) {
+
+	fleet.mutex.Lock()
+	records := make([]StatsRecord, 0, len(fleet.records))
+	for _, record := range fleet.records {
+		records = append(records, record)
+	}
+	requested := fleet.requested
+	fleet.mutex.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].HostPort < records[j].HostPort
+	})
+
+	fmt.Fprintf(w, "# HELP tlex_containers_requested Number of containers requested.\n# TYPE tlex_containers_requested gauge\ntlex_containers_requested %d\n", requested)
+	fmt.Fprintf(w, "# HELP tlex_containers_live Number of live containers streaming stats.\n# TYPE tlex_containers_live gauge\ntlex_containers_live %d\n", len(records))
+
+	for _, metric := range containerMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.metricType)
+		for _, record := range records {
+			fmt.Fprintf(w, "%s{container_id=%q,host_port=\"%d\"} %s\n", metric.name, record.ContainerID, record.HostPort, metric.value(record))
+		}
+	}
+}
+
+// serveMetrics adds an actor to g serving the fleet metrics at http://address/metrics.
+// It returns the listening address, which differs from address for port 0.
+func serveMetrics(address string, g *run.Group, fleet *fleetMetrics) (net.Addr, error) {
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve the metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", fleet)
+	server := &http.Server{Handler: mux}
+	log.Printf("Serving Prometheus metrics at http://%s/metrics\n", listener.Addr())
+
+	g.Add(func() error {
+
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			return err
+		}
+
+		return nil
+
+	}, func(error) {
+
+		server.Close()
+
+	})
+
+	return listener.Addr(), nil
+}
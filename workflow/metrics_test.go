@@ -0,0 +1,76 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oklog/run"
+)
+
+func Test_Metrics_Exposition(t *testing.T) {
+
+	fleet := newFleetMetrics(3)
+	fleet.observe(StatsRecord{HostPort: 8771, ContainerID: "b2", CPUPercent: 12.5, MemoryUsageBytes: 2048, NetworkRxBytes: 10, PIDs: 4})
+	fleet.observe(StatsRecord{HostPort: 8770, ContainerID: "a1", CPUPercent: 1.5, MemoryUsageBytes: 1024, NetworkRxBytes: 20, PIDs: 2})
+	fleet.observe(StatsRecord{HostPort: 8772, ContainerID: "c3"})
+	fleet.forget("c3")
+
+	recorder := httptest.NewRecorder()
+	fleet.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", contentType)
+	}
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE tlex_containers_requested gauge\ntlex_containers_requested 3\n",
+		"tlex_containers_live 2\n",
+		"# TYPE tlex_container_cpu_percent gauge\n" +
+			"tlex_container_cpu_percent{container_id=\"a1\",host_port=\"8770\"} 1.5\n" +
+			"tlex_container_cpu_percent{container_id=\"b2\",host_port=\"8771\"} 12.5\n",
+		"tlex_container_memory_usage_bytes{container_id=\"b2\",host_port=\"8771\"} 2048\n",
+		"# TYPE tlex_container_network_receive_bytes_total counter\n",
+		"tlex_container_network_receive_bytes_total{container_id=\"a1\",host_port=\"8770\"} 20\n",
+		"tlex_container_pids{container_id=\"a1\",host_port=\"8770\"} 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics is missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "c3") {
+		t.Errorf("/metrics exports the forgotten container c3:\n%s", body)
+	}
+}
+
+func Test_Metrics_Endpoint_Serves_Until_Interrupted(t *testing.T) {
+
+	var g run.Group
+	fleet := newFleetMetrics(1)
+	address, err := serveMetrics("127.0.0.1:0", &g, fleet)
+	if err != nil {
+		t.Fatalf("serveMetrics() error = %v", err)
+	}
+
+	// Scraping once ends the group, interrupting the server.
+	var body []byte
+	g.Add(func() error {
+		response, err := http.Get("http://" + address.String() + "/metrics")
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		body, err = ioutil.ReadAll(response.Body)
+		return err
+	}, func(error) {})
+
+	if err = g.Run(); err != nil {
+		t.Fatalf("Scraping /metrics error = %v", err)
+	}
+	if !strings.Contains(string(body), "tlex_containers_requested 1\n") {
+		t.Errorf("/metrics = %s", body)
+	}
+}
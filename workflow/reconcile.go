@@ -0,0 +1,310 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+
+	"github.com/oklog/run"
+)
+
+// fleetHealer keeps the owned containers live by relaunching the dead ones on their host port, see reconcile.
+// The stream supervisors follow the relaunched containers, see replacement.
+type fleetHealer struct {
+	cfg          config.AppConfig
+	dockerClient dockerapi.ContainerEngine
+	runID        string
+
+	// persistMutex orders the state file updates.
+	persistMutex sync.Mutex
+
+	mutex sync.Mutex
+	// owned holds the live or dead but not yet replaced containers.
+	owned dockerapi.OwnedContainers
+	// By host port: relaunch attempts against the MaxRestarts budget, successful relaunches,
+	// relaunch backoffs kept across deaths so a crash looping container backs off longer.
+	attempts map[int]int
+	restarts map[int]int
+	backoffs map[int]*backoff
+	// relaunching host ports are being relaunched, given up ones spent their budget.
+	relaunching map[int]bool
+	givenUp     map[int]bool
+	// changed is closed and renewed when a container is replaced or a host port given up.
+	changed chan struct{}
+	// wake triggers a check ahead of the next ReconcileInterval, see observe.
+	wake chan struct{}
+}
+
+// newFleetHealer returns the healer of the owned containers of the runID launch,
+// or nil when self-healing is disabled or there are no containers.
+func newFleetHealer(cfg config.AppConfig, dockerClient dockerapi.ContainerEngine, runID string, ownedContainers dockerapi.OwnedContainers) *fleetHealer {
+
+	if cfg.MaxRestarts == 0 || len(ownedContainers) == 0 {
+		return nil
+	}
+
+	owned := make(dockerapi.OwnedContainers, len(ownedContainers))
+	for containerID, hostPort := range ownedContainers {
+		owned[containerID] = hostPort
+	}
+
+	return &fleetHealer{
+		cfg:          cfg,
+		dockerClient: dockerClient,
+		runID:        runID,
+		owned:        owned,
+		attempts:     make(map[int]int),
+		restarts:     make(map[int]int),
+		backoffs:     make(map[int]*backoff),
+		relaunching:  make(map[int]bool),
+		givenUp:      make(map[int]bool),
+		changed:      make(chan struct{}),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// reconcile adds an actor to g checking the owned containers every ReconcileInterval until interrupted
+// and relaunching the dead ones concurrently. A nil healer adds none.
+func (healer *fleetHealer) reconcile(ctx context.Context, g *run.Group) {
+
+	if healer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g.Add(func() error {
+
+		var relaunchers sync.WaitGroup
+		defer relaunchers.Wait()
+
+		ticker := time.NewTicker(time.Duration(healer.cfg.ReconcileInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			case <-healer.wake:
+			}
+
+			dead, err := healer.deadContainers(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Checking the live containers failed: %v\n", err)
+				}
+				continue
+			}
+			for containerID, hostPort := range dead {
+				relaunchers.Add(1)
+				go func(containerID string, hostPort int) {
+					defer relaunchers.Done()
+					healer.relaunch(ctx, containerID, hostPort)
+				}(containerID, hostPort)
+			}
+		}
+
+	}, func(error) {
+
+		cancel()
+
+	})
+}
+
+// observe checks the owned containers at once when a container event tells one died.
+// A nil healer ignores the events.
+func (healer *fleetHealer) observe(event dockerapi.ContainerEvent) {
+
+	if healer == nil || event.Action != dockerapi.EventDie {
+		return
+	}
+
+	select {
+	case healer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deadContainers lists the owned containers no longer running, neither being relaunched nor given up,
+// and marks them being relaunched.
+func (healer *fleetHealer) deadContainers(ctx context.Context) (dockerapi.OwnedContainers, error) {
+
+	containers, err := dockerapi.Owner{RunID: healer.runID}.ListContainers(ctx, healer.dockerClient)
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		running[container.ID] = true
+	}
+
+	healer.mutex.Lock()
+	defer healer.mutex.Unlock()
+
+	dead := make(dockerapi.OwnedContainers)
+	for containerID, hostPort := range healer.owned {
+		if !running[containerID] && !healer.relaunching[hostPort] && !healer.givenUp[hostPort] {
+			healer.relaunching[hostPort] = true
+			dead[containerID] = hostPort
+		}
+	}
+
+	return dead, nil
+}
+
+// relaunch replaces the dead container at hostPort by a new one, retrying after a backoff
+// until it is live, the host port's MaxRestarts budget is spent or ctx is done.
+func (healer *fleetHealer) relaunch(ctx context.Context, deadID string, hostPort int) {
+
+	healer.mutex.Lock()
+	retry, ok := healer.backoffs[hostPort]
+	if !ok {
+		retry = newBackoff(time.Duration(healer.cfg.RestartBackoff), time.Duration(healer.cfg.RestartMaxBackoff))
+		healer.backoffs[hostPort] = retry
+	}
+	healer.mutex.Unlock()
+
+	for {
+		healer.mutex.Lock()
+		spent := healer.attempts[hostPort] >= healer.cfg.MaxRestarts
+		if spent {
+			healer.givenUp[hostPort] = true
+			healer.notify()
+		} else {
+			healer.attempts[hostPort]++
+		}
+		healer.mutex.Unlock()
+		if spent {
+			log.Printf("Container %.12s at port %d is dead and its %d restarts are spent. It is not relaunched.\n", deadID, hostPort, healer.cfg.MaxRestarts)
+			return
+		}
+
+		wait := retry.wait()
+		log.Printf("Container %.12s at port %d is dead. Relaunching it in %v.\n", deadID, hostPort, wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		containerID, err := healer.launch(ctx, hostPort)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Relaunching the container at port %d failed: %v\n", hostPort, err)
+			continue
+		}
+
+		healer.replace(ctx, deadID, containerID, hostPort)
+		return
+	}
+}
+
+// launch launches a container at hostPort within the configured limits.
+func (healer *fleetHealer) launch(ctx context.Context, hostPort int) (string, error) {
+
+	limits, err := containerLimits(healer.cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return dockerapi.LaunchContainer(ctx, healer.dockerClient, healer.runID, healer.cfg.DockerImageName, limits, healer.cfg.DockerExposedPort, hostPort, time.Duration(healer.cfg.LaunchTimeout))
+}
+
+// replace records containerID relaunched in place of deadID at hostPort and persists the owned containers.
+func (healer *fleetHealer) replace(ctx context.Context, deadID string, containerID string, hostPort int) {
+
+	healer.mutex.Lock()
+	delete(healer.owned, deadID)
+	healer.owned[containerID] = hostPort
+	healer.restarts[hostPort]++
+	restarts := healer.restarts[hostPort]
+	healer.relaunching[hostPort] = false
+	healer.notify()
+	healer.mutex.Unlock()
+
+	log.Printf("Relaunched the dead container %.12s at port %d as %.12s, restart %d of %d.\n", deadID, hostPort, containerID, restarts, healer.cfg.MaxRestarts)
+
+	if err := healer.persist(ctx); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// persist saves the owned containers and their restarts to the state file.
+func (healer *fleetHealer) persist(ctx context.Context) error {
+
+	healer.persistMutex.Lock()
+	defer healer.persistMutex.Unlock()
+
+	healer.mutex.Lock()
+	owned := make(dockerapi.OwnedContainers, len(healer.owned))
+	for containerID, hostPort := range healer.owned {
+		owned[containerID] = hostPort
+	}
+	restarts := make(map[int]int, len(healer.restarts))
+	for hostPort, count := range healer.restarts {
+		restarts[hostPort] = count
+	}
+	healer.mutex.Unlock()
+
+	return owned.PersistOwnedContainers(ctx, healer.dockerClient, healer.runID, restarts)
+}
+
+// notify wakes up the replacement waiters. The caller holds the mutex.
+func (healer *fleetHealer) notify() {
+
+	close(healer.changed)
+	healer.changed = make(chan struct{})
+}
+
+// replacement waits for the container relaunched in place of the exited containerID at hostPort.
+// It returns false once the host port is given up, when ctx is done or for a nil healer.
+func (healer *fleetHealer) replacement(ctx context.Context, containerID string, hostPort int) (string, bool) {
+
+	if healer == nil {
+		return "", false
+	}
+
+	for {
+		healer.mutex.Lock()
+		current := ""
+		for ownedID, ownedPort := range healer.owned {
+			if ownedPort == hostPort {
+				current = ownedID
+			}
+		}
+		givenUp, changed := healer.givenUp[hostPort], healer.changed
+		healer.mutex.Unlock()
+
+		switch {
+		case current != "" && current != containerID:
+			return current, true
+		case givenUp || ctx.Err() != nil:
+			return "", false
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		}
+	}
+}
+
+// restartsAt returns the relaunches at hostPort, 0 for a nil healer.
+func (healer *fleetHealer) restartsAt(hostPort int) int {
+
+	if healer == nil {
+		return 0
+	}
+
+	healer.mutex.Lock()
+	defer healer.mutex.Unlock()
+
+	return healer.restarts[hostPort]
+}
@@ -0,0 +1,139 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tlex/config"
+	"tlex/logger"
+	"tlex/state"
+
+	"github.com/oklog/run"
+)
+
+// healingFleet runs the healer of the 2 containers of superviseFleet with a budget of maxRestarts
+// and the supervised log and stats streams.
+func healingFleet(t *testing.T, maxRestarts int) (healer *fleetHealer, sink *recordingSink, fleet *fleetMetrics, stop func()) {
+
+	fake, cfg, owned, _ := superviseFleet(t)
+	cfg.DockerImageName = "echo:latest"
+	cfg.MaxRestarts = maxRestarts
+	cfg.RestartBackoff = config.Duration(10 * time.Millisecond)
+	cfg.RestartMaxBackoff = config.Duration(20 * time.Millisecond)
+	cfg.ReconcileInterval = config.Duration(10 * time.Millisecond)
+
+	sink = &recordingSink{}
+	containersLogger := logger.NewLogger(logger.Options{}, sink)
+	statsLogger := logger.NewLogger(logger.Options{}, &recordingSink{})
+	fleet = newFleetMetrics(2)
+	healer = newFleetHealer(cfg, fake, "run", owned)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g run.Group
+	healer.reconcile(ctx, &g)
+	if err := aggContainersLogStreams(ctx, containersLogger, fake, cfg, &g, owned, healer); err != nil {
+		t.Fatalf("aggContainersLogStreams() error = %v", err)
+	}
+	if err := monitorContainerStatStreams(ctx, statsLogger, fake, cfg, &g, owned, fleet, healer); err != nil {
+		t.Fatalf("monitorContainerStatStreams() error = %v", err)
+	}
+	_, stopGroup := runGroup(t, &g)
+
+	return healer, sink, fleet, func() {
+		cancel()
+		stopGroup()
+		containersLogger.Close()
+		statsLogger.Close()
+		state.Delete(state.Filename)
+	}
+}
+
+// containerAt returns the healer's container at hostPort.
+func (healer *fleetHealer) containerAt(hostPort int) string {
+
+	healer.mutex.Lock()
+	defer healer.mutex.Unlock()
+
+	for containerID, ownedPort := range healer.owned {
+		if ownedPort == hostPort {
+			return containerID
+		}
+	}
+
+	return ""
+}
+
+func Test_Dead_Containers_Relaunch_Fake_Engine(t *testing.T) {
+
+	healer, sink, fleet, stop := healingFleet(t, 2)
+	defer stop()
+
+	await(t, "the first log line", func() bool {
+		return sink.count("Log line 0 of HttpServerAt_8770") == 1
+	})
+	deadID := healer.containerAt(8770)
+	if err := healer.dockerClient.ContainerStop(context.Background(), deadID, nil); err != nil {
+		t.Fatalf("ContainerStop() error = %v", err)
+	}
+
+	await(t, "the relaunched container", func() bool {
+		return healer.containerAt(8770) != deadID
+	})
+	relaunchedID := healer.containerAt(8770)
+
+	// The relaunched container numbers its log lines from 0 again.
+	await(t, "the relaunched container's log stream", func() bool {
+		return sink.count("Log line 0 of HttpServerAt_8770") == 2
+	})
+	await(t, "the relaunched container's stats", func() bool {
+		fleet.mutex.Lock()
+		defer fleet.mutex.Unlock()
+		_, deadLive := fleet.records[deadID]
+		return !deadLive && fleet.records[relaunchedID].Restarts == 1
+	})
+
+	launchState, err := state.Load(state.Filename)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	for _, cont := range launchState.Containers {
+		if cont.HostPort == 8770 && (cont.ID != relaunchedID || cont.Restarts != 1) {
+			t.Errorf("Persisted container = %+v, want %.12s with 1 restart", cont, relaunchedID)
+		}
+		if cont.HostPort == 8771 && cont.Restarts != 0 {
+			t.Errorf("Persisted container = %+v, want no restarts", cont)
+		}
+	}
+}
+
+func Test_Restarts_Budget_Fake_Engine(t *testing.T) {
+
+	healer, _, _, stop := healingFleet(t, 1)
+	defer stop()
+
+	deadID := healer.containerAt(8771)
+	healer.dockerClient.ContainerStop(context.Background(), deadID, nil)
+	await(t, "the relaunched container", func() bool {
+		return healer.containerAt(8771) != deadID
+	})
+
+	deadID = healer.containerAt(8771)
+	healer.dockerClient.ContainerStop(context.Background(), deadID, nil)
+	await(t, "the spent restarts budget", func() bool {
+		healer.mutex.Lock()
+		defer healer.mutex.Unlock()
+		return healer.givenUp[8771]
+	})
+
+	if containerID := healer.containerAt(8771); containerID != deadID {
+		t.Errorf("Container at port 8771 = %.12s, want the dead %.12s", containerID, deadID)
+	}
+	if restarts := healer.restartsAt(8771); restarts != 1 {
+		t.Errorf("restartsAt(8771) = %d, want 1", restarts)
+	}
+	if restarts := healer.restartsAt(8770); restarts != 0 {
+		t.Errorf("restartsAt(8770) = %d, want 0", restarts)
+	}
+}
@@ -0,0 +1,158 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"tlex/config"
+
+	"github.com/docker/docker/api/types"
+)
+
+const bytes2MiB float64 = 1024 * 1024
+const bytes2GiB float64 = bytes2MiB * 1024
+
+// StatsRecord is a container stats snapshot with its computed metrics.
+// It is written as a JSON object per line in the json stats format.
+type StatsRecord struct {
+	HostPort         int       `json:"hostPort"`
+	ContainerID      string    `json:"containerId"`
+	Timestamp        time.Time `json:"timestamp"`
+	CPUPercent       float64   `json:"cpuPercent"`
+	MemoryPercent    float64   `json:"memoryPercent"`
+	MemoryUsageBytes uint64    `json:"memoryUsageBytes"`
+	MemoryLimitBytes uint64    `json:"memoryLimitBytes"`
+	NetworkRxBytes   uint64    `json:"networkRxBytes"`
+	NetworkTxBytes   uint64    `json:"networkTxBytes"`
+	BlockReadBytes   uint64    `json:"blockReadBytes"`
+	BlockWriteBytes  uint64    `json:"blockWriteBytes"`
+	PIDs             uint64    `json:"pids"`
+	// Configured limits next to the usage, 0 for unlimited: CPUs and processes.
+	// MemoryLimitBytes is the daemon's, the configured memory limit or the host memory.
+	CPULimit  float64 `json:"cpuLimit"`
+	PIDsLimit uint64  `json:"pidsLimit"`
+	// Restarts counts the relaunches of dead containers at the host port.
+	Restarts int `json:"restarts"`
+}
+
+// statsCalculator computes the metrics of a container's consecutive stats samples the way docker stats does.
+// It is not safe for concurrent use; each container stream owns one.
+type statsCalculator struct {
+	previousCPU types.CPUStats
+	hasPrevious bool
+}
+
+// record computes the metrics of the stats sample of the containerID at hostPort and keeps the
+// sample as the previous one of the next CPU delta.
+// Network and block IO bytes are summed across interfaces and devices.
+func (calc *statsCalculator) record(hostPort int, containerID string, stats *types.StatsJSON) StatsRecord {
+
+	memoryUsage := memoryUsageNoCache(stats.MemoryStats)
+	record := StatsRecord{
+		HostPort:         hostPort,
+		ContainerID:      containerID,
+		Timestamp:        stats.Read,
+		CPUPercent:       calc.cpuPercent(stats.CPUStats, stats.PreCPUStats),
+		MemoryUsageBytes: memoryUsage,
+		MemoryLimitBytes: stats.MemoryStats.Limit,
+		PIDs:             stats.PidsStats.Current,
+	}
+	if stats.MemoryStats.Limit != 0 {
+		record.MemoryPercent = float64(memoryUsage) / float64(stats.MemoryStats.Limit) * 100.0
+	}
+	for _, network := range stats.Networks {
+		record.NetworkRxBytes += network.RxBytes
+		record.NetworkTxBytes += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			record.BlockReadBytes += entry.Value
+		case "write":
+			record.BlockWriteBytes += entry.Value
+		}
+	}
+
+	return record
+}
+
+// cpuPercent returns the share of the host CPUs used since the previous sample, or since
+// the daemon's preCPU sample for the first one, scaled by the online CPUs as docker stats does.
+func (calc *statsCalculator) cpuPercent(cpu types.CPUStats, preCPU types.CPUStats) float64 {
+
+	if calc.hasPrevious {
+		preCPU = calc.previousCPU
+	}
+	calc.previousCPU, calc.hasPrevious = cpu, true
+
+	// Cumulative counters reset when the container restarts.
+	if cpu.CPUUsage.TotalUsage <= preCPU.CPUUsage.TotalUsage || cpu.SystemUsage <= preCPU.SystemUsage {
+		return 0
+	}
+	cpuDelta := float64(cpu.CPUUsage.TotalUsage - preCPU.CPUUsage.TotalUsage)
+	systemDelta := float64(cpu.SystemUsage - preCPU.SystemUsage)
+
+	onlineCPUs := float64(cpu.OnlineCPUs)
+	if onlineCPUs == 0 {
+		// Daemons predating online_cpus on cgroup v1
+		onlineCPUs = float64(len(cpu.CPUUsage.PercpuUsage))
+	}
+
+	return cpuDelta / systemDelta * onlineCPUs * 100.0
+}
+
+// memoryUsageNoCache returns the memory usage without the reclaimable page cache like docker stats.
+// cgroup v1 reports total_inactive_file and cgroup v2 inactive_file; cache is the last fallback for older daemons.
+func memoryUsageNoCache(memory types.MemoryStats) uint64 {
+
+	for _, pageCache := range []string{"total_inactive_file", "inactive_file", "cache"} {
+		if value, ok := memory.Stats[pageCache]; ok {
+			if value < memory.Usage {
+				return memory.Usage - value
+			}
+			return memory.Usage
+		}
+	}
+
+	return memory.Usage
+}
+
+// formatStats renders the resourceSnapshotCnt stats snapshot and its computed record in statsFormat.
+func formatStats(statsFormat string, resourceSnapshotCnt int, record StatsRecord, stats *types.StatsJSON) string {
+
+	if statsFormat != config.StatsFormatJSON {
+		return formatTextStats(resourceSnapshotCnt, record, stats)
+	}
+
+	statsLine, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprintf("{\"hostPort\":%d,\"error\":%q}", record.HostPort, err.Error())
+	}
+
+	return string(statsLine)
+}
+
+// formatTextStats renders the resourceSnapshotCnt stats snapshot and its computed record as a text block.
+func formatTextStats(resourceSnapshotCnt int, record StatsRecord, stats *types.StatsJSON) string {
+
+	statsBuilder := strings.Builder{}
+	statsBuilder.WriteRune('\n')
+	pids := fmt.Sprintf("%d", stats.PidsStats.Current)
+	if record.PIDsLimit > 0 {
+		pids = fmt.Sprintf("%s/%d", pids, record.PIDsLimit)
+	}
+	// CPU percents are of a single CPU like docker stats, so a limit of 1.5 CPUs caps the usage at 150%.
+	cpuLimit := "none"
+	if record.CPULimit > 0 {
+		cpuLimit = fmt.Sprintf("%.2f%% (%v CPUs)", record.CPULimit*100, record.CPULimit)
+	}
+	statsBuilder.WriteString(fmt.Sprintf("Resource Snaphot %d for http server @ port %d, PIDs:%s, Restarts:%d\n", resourceSnapshotCnt, record.HostPort, pids, record.Restarts))
+	statsBuilder.WriteString(fmt.Sprintf("CPU -> CPU %.2f%%, Limit: %s, CPUs: %v, Usage Total: %v, System: %v\n", record.CPUPercent, cpuLimit, stats.CPUStats.OnlineCPUs, stats.CPUStats.CPUUsage.TotalUsage, stats.CPUStats.SystemUsage))
+	statsBuilder.WriteString(fmt.Sprintf("Memory -> %.2f%% Usage: %.2fMiB, MaxUsage: %.2fMiB, Limit: %.2fGiB\n", record.MemoryPercent, float64(record.MemoryUsageBytes)/bytes2MiB, float64(stats.MemoryStats.MaxUsage)/bytes2MiB, float64(stats.MemoryStats.Limit)/bytes2GiB))
+	statsBuilder.WriteString(fmt.Sprintf("IO -> StorageStats.ReadSizeBytes: %v, Time: %v, Wait Time: %v, Serviced: %v, Service Bytes: %v, Queued: %v\n", stats.StorageStats.ReadSizeBytes, stats.BlkioStats.IoTimeRecursive, stats.BlkioStats.IoWaitTimeRecursive, stats.BlkioStats.IoServicedRecursive, stats.BlkioStats.IoServiceBytesRecursive, stats.BlkioStats.IoQueuedRecursive))
+
+	return statsBuilder.String()
+}
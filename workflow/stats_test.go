@@ -0,0 +1,141 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"tlex/config"
+
+	"github.com/docker/docker/api/types"
+)
+
+func testStatsJSON() *types.StatsJSON {
+
+	var stats types.StatsJSON
+	stats.ID = "c0ffee"
+	stats.Read = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stats.PidsStats.Current = 3
+	stats.MemoryStats.Usage = 256 * 1024 * 1024
+	stats.MemoryStats.Limit = 1024 * 1024 * 1024
+	stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 100, TxBytes: 50}, "eth1": {RxBytes: 10, TxBytes: 5}}
+	stats.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{{Op: "Read", Value: 4096}, {Op: "Write", Value: 512}, {Op: "Total", Value: 4608}, {Op: "read", Value: 4096}}
+
+	return &stats
+}
+
+func Test_JSON_Stats_Line_Has_Computed_Metrics(t *testing.T) {
+
+	var calc statsCalculator
+	stats := testStatsJSON()
+	statsLine := formatStats(config.StatsFormatJSON, 0, calc.record(8770, "c0ffee", stats), stats)
+	if strings.Contains(statsLine, "\n") {
+		t.Errorf("formatStats() json = %q spans several lines", statsLine)
+	}
+
+	var record StatsRecord
+	if err := json.Unmarshal([]byte(statsLine), &record); err != nil {
+		t.Fatalf("formatStats() json = %q is not a StatsRecord: %v", statsLine, err)
+	}
+	want := StatsRecord{
+		HostPort:         8770,
+		ContainerID:      "c0ffee",
+		Timestamp:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		MemoryPercent:    25,
+		MemoryUsageBytes: 256 * 1024 * 1024,
+		MemoryLimitBytes: 1024 * 1024 * 1024,
+		NetworkRxBytes:   110,
+		NetworkTxBytes:   55,
+		BlockReadBytes:   8192,
+		BlockWriteBytes:  512,
+		PIDs:             3,
+	}
+	if record != want {
+		t.Errorf("formatStats() json = %+v, want %+v", record, want)
+	}
+}
+
+func Test_Text_Stats_Is_The_Default(t *testing.T) {
+
+	var calc statsCalculator
+	stats := testStatsJSON()
+	statsText := formatStats(config.GetConfig().StatsFormat, 7, calc.record(8770, "c0ffee", stats), stats)
+	if !strings.Contains(statsText, "Resource Snaphot 7 for http server @ port 8770, PIDs:3") {
+		t.Errorf("formatStats() text = %q", statsText)
+	}
+}
+
+func Test_Stats_Show_The_Configured_Limits(t *testing.T) {
+
+	var calc statsCalculator
+	stats := testStatsJSON()
+	record := calc.record(8770, "c0ffee", stats)
+	record.CPULimit, record.PIDsLimit = 1.5, 100
+
+	statsText := formatStats(config.StatsFormatText, 0, record, stats)
+	for _, want := range []string{"PIDs:3/100, Restarts:0", "CPU -> CPU 0.00%, Limit: 150.00% (1.5 CPUs)", "Limit: 1.00GiB"} {
+		if !strings.Contains(statsText, want) {
+			t.Errorf("formatStats() text = %q, want it to contain %q", statsText, want)
+		}
+	}
+	statsLine := formatStats(config.StatsFormatJSON, 0, record, stats)
+	if !strings.Contains(statsLine, `"cpuLimit":1.5,"pidsLimit":100`) {
+		t.Errorf("formatStats() json = %q, want the configured limits", statsLine)
+	}
+
+	unlimited := formatStats(config.StatsFormatText, 0, calc.record(8770, "c0ffee", stats), stats)
+	if !strings.Contains(unlimited, "PIDs:3, Restarts:0") || !strings.Contains(unlimited, "Limit: none") {
+		t.Errorf("formatStats() text without limits = %q", unlimited)
+	}
+}
+
+func Test_CPU_Percent_Is_Delta_Based(t *testing.T) {
+
+	sample := func(totalUsage, systemUsage uint64, onlineCPUs uint32, percpu int) types.CPUStats {
+		cpu := types.CPUStats{SystemUsage: systemUsage, OnlineCPUs: onlineCPUs}
+		cpu.CPUUsage.TotalUsage = totalUsage
+		cpu.CPUUsage.PercpuUsage = make([]uint64, percpu)
+		return cpu
+	}
+
+	var calc statsCalculator
+	// The 1st sample is relative to the daemon's preCPU sample.
+	if got := calc.cpuPercent(sample(2e9, 40e9, 4, 0), sample(1e9, 20e9, 4, 0)); got != 20 {
+		t.Errorf("cpuPercent() of the 1st sample = %v, want 20", got)
+	}
+	// A lifetime average would be 2.5e9/42e9*4*100 = 23.8%.
+	if got := calc.cpuPercent(sample(2.5e9, 42e9, 4, 0), types.CPUStats{}); got != 100 {
+		t.Errorf("cpuPercent() of the 2nd sample = %v, want 100 i.e. the delta from the 1st", got)
+	}
+	// Daemons without online_cpus count the per CPU usage.
+	if got := calc.cpuPercent(sample(3e9, 44e9, 0, 2), types.CPUStats{}); got != 50 {
+		t.Errorf("cpuPercent() without online cpus = %v, want 50", got)
+	}
+	// Counters reset by a restart.
+	if got := calc.cpuPercent(sample(1e8, 1e9, 4, 0), types.CPUStats{}); got != 0 {
+		t.Errorf("cpuPercent() after a counters reset = %v, want 0", got)
+	}
+}
+
+func Test_Memory_Usage_Subtracts_Page_Cache(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		stats map[string]uint64
+		want  uint64
+	}{
+		{"cgroup v1", map[string]uint64{"total_inactive_file": 30, "inactive_file": 20, "cache": 50}, 70},
+		{"cgroup v1 older daemon", map[string]uint64{"cache": 50}, 50},
+		{"cgroup v2", map[string]uint64{"inactive_file": 40, "file": 60}, 60},
+		{"inactive file before cache", map[string]uint64{"inactive_file": 40, "cache": 50}, 60},
+		{"no page cache", nil, 100},
+		{"page cache above usage", map[string]uint64{"inactive_file": 400}, 100},
+	}
+	for _, tt := range tests {
+		if got := memoryUsageNoCache(types.MemoryStats{Usage: 100, Stats: tt.stats}); got != tt.want {
+			t.Errorf("memoryUsageNoCache() of %s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+
+	"github.com/oklog/run"
+)
+
+// backoff doubles a wait from initial up to max.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	next    time.Duration
+}
+
+func newBackoff(initial time.Duration, max time.Duration) *backoff {
+
+	return &backoff{initial: initial, max: max, next: initial}
+}
+
+// wait returns the next wait, doubling the following one.
+func (b *backoff) wait() time.Duration {
+
+	wait := b.next
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+
+	return wait
+}
+
+func (b *backoff) reset() {
+
+	b.next = b.initial
+}
+
+// streamSupervisor keeps a container stream attached, see superviseStream.
+type streamSupervisor struct {
+	cfg          config.AppConfig
+	dockerClient dockerapi.ContainerEngine
+	// kind names the stream in reports e.g. log, stats.
+	kind        string
+	containerID string
+	hostPort    int
+	// open opens the stream of containerID, consume reads it until it ends returning nil at its end of file.
+	open    func(ctx context.Context, containerID string) (dockerapi.ContainerReaderStream, error)
+	consume func(stream dockerapi.ContainerReaderStream) error
+	// healer relaunches exited containers unless nil. replaced, if set, is called before the supervisor
+	// follows the container relaunched in place of containerID.
+	healer   *fleetHealer
+	replaced func()
+}
+
+// superviseStream reads the container stream until ctx is done or the container exits.
+// A stream failing to open or ending while the container runs is reopened after a backoff,
+// reset once a stream stays open for the longest backoff. The exit of the container is reported
+// and the stream of its relaunched replacement, if any, is followed.
+func (supervisor *streamSupervisor) superviseStream(ctx context.Context) {
+
+	retry := newBackoff(time.Duration(supervisor.cfg.StreamReattachBackoff), time.Duration(supervisor.cfg.StreamReattachMaxBackoff))
+	for {
+		stream, err := supervisor.open(ctx, supervisor.containerID)
+		if err == nil {
+			openedAt := time.Now()
+			err = supervisor.consume(stream)
+			stream.ReaderStream.Close()
+			if time.Since(openedAt) >= retry.max {
+				retry.reset()
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		exited, exitCode, inspectErr := dockerapi.ContainerExited(ctx, supervisor.dockerClient, supervisor.containerID)
+		if inspectErr == nil && exited {
+			exit := fmt.Sprintf("exited with code %d", exitCode)
+			if exitCode < 0 {
+				exit = "is gone"
+			}
+			containerID, relaunched := supervisor.healer.replacement(ctx, supervisor.containerID, supervisor.hostPort)
+			if !relaunched {
+				log.Printf("Container %.12s at port %d %s. Its %s stream is detached.\n", supervisor.containerID, supervisor.hostPort, exit, supervisor.kind)
+				return
+			}
+			log.Printf("Container %.12s at port %d %s. Its %s stream follows the relaunched container %.12s.\n", supervisor.containerID, supervisor.hostPort, exit, supervisor.kind, containerID)
+			if supervisor.replaced != nil {
+				supervisor.replaced()
+			}
+			supervisor.containerID = containerID
+			retry.reset()
+			continue
+		}
+
+		wait := retry.wait()
+		if err == nil {
+			log.Printf("The %s stream of container %.12s at port %d ended. Reattaching in %v.\n", supervisor.kind, supervisor.containerID, supervisor.hostPort, wait)
+		} else {
+			log.Printf("The %s stream of container %.12s at port %d failed: %v. Reattaching in %v.\n", supervisor.kind, supervisor.containerID, supervisor.hostPort, err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// superviseStreams adds an actor to g running the supervisors until interrupted, so a container exiting
+// does not end the monitoring of the rest. No actor is added for no supervisors.
+func superviseStreams(ctx context.Context, g *run.Group, supervisors []func(context.Context)) {
+
+	if len(supervisors) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g.Add(func() error {
+
+		var supervisorsGroup sync.WaitGroup
+		for _, supervise := range supervisors {
+			supervisorsGroup.Add(1)
+			go func(supervise func(context.Context)) {
+				defer supervisorsGroup.Done()
+				supervise(ctx)
+			}(supervise)
+		}
+		supervisorsGroup.Wait()
+		<-ctx.Done()
+
+		return nil
+
+	}, func(error) {
+
+		cancel()
+
+	})
+}
@@ -0,0 +1,215 @@
+// Package workflow holds the state machine + specific requirements fulfillment.
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tlex/config"
+	"tlex/dockerapi"
+	"tlex/internal/fakeengine"
+	"tlex/logger"
+
+	"github.com/oklog/run"
+	"golang.org/x/sync/errgroup"
+)
+
+// recordingSink keeps the messages written to it.
+type recordingSink struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (sink *recordingSink) Write(message logger.Message) error {
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.messages = append(sink.messages, message.Text)
+
+	return nil
+}
+
+func (sink *recordingSink) Flush() error { return nil }
+
+func (sink *recordingSink) Close() error { return nil }
+
+func (sink *recordingSink) String() string { return "recording" }
+
+// count returns the number of messages containing substr.
+func (sink *recordingSink) count(substr string) int {
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	n := 0
+	for _, message := range sink.messages {
+		if strings.Contains(message, substr) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// await fails the test unless done turns true within 5 seconds.
+func await(t *testing.T, what string, done func() bool) {
+
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// superviseFleet launches 2 containers at ports 8770 and 8771 on a fakeengine.Engine and returns them by host port
+// with a config reattaching streams quickly.
+func superviseFleet(t *testing.T) (*fakeengine.Engine, config.AppConfig, dockerapi.OwnedContainers, map[int]string) {
+
+	fake := fakeengine.New()
+	cfg := config.GetConfig()
+	cfg.StatsDisplay = false
+	cfg.StreamReattachBackoff = config.Duration(10 * time.Millisecond)
+	cfg.StreamReattachMaxBackoff = config.Duration(50 * time.Millisecond)
+
+	var launcherGroup errgroup.Group
+	owned := make(dockerapi.OwnedContainers)
+	owned.CreateContainers(context.Background(), &launcherGroup, nil, 2, fake, "run", "echo:latest", nil, dockerapi.NewPortAllocator(8770, 0, nil), 8770, 0)
+	if err := launcherGroup.Wait(); err != nil {
+		t.Fatalf("CreateContainers() error = %v", err)
+	}
+
+	byPort := make(map[int]string, len(owned))
+	for containerID, hostPort := range owned {
+		byPort[hostPort] = containerID
+	}
+
+	return fake, cfg, owned, byPort
+}
+
+// runGroup runs g until cancel is called and checks it ends without error.
+func runGroup(t *testing.T, g *run.Group) (running func() bool, stop func()) {
+
+	ctxDone := make(chan error, 1)
+	go func() {
+		ctxDone <- g.Run()
+	}()
+
+	return func() bool {
+			select {
+			case err := <-ctxDone:
+				ctxDone <- err
+				return false
+			default:
+				return true
+			}
+		}, func() {
+			select {
+			case err := <-ctxDone:
+				if err != nil {
+					t.Errorf("run.Group.Run() error = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("run.Group.Run() did not end when interrupted")
+			}
+		}
+}
+
+func Test_Supervised_Log_Streams_Reattach_Fake_Engine(t *testing.T) {
+
+	fake, cfg, owned, byPort := superviseFleet(t)
+
+	sink := &recordingSink{}
+	containersLogger := logger.NewLogger(logger.Options{}, sink)
+	defer containersLogger.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g run.Group
+	if err := aggContainersLogStreams(ctx, containersLogger, fake, cfg, &g, owned, nil); err != nil {
+		t.Fatalf("aggContainersLogStreams() error = %v", err)
+	}
+	running, stop := runGroup(t, &g)
+
+	await(t, "the first log lines", func() bool {
+		return sink.count("HttpServerAt_8770") > 0 && sink.count("HttpServerAt_8771") > 0
+	})
+
+	// The reopened stream resumes at the last line read, neither skipping nor repeating a line.
+	fake.DropStreams(byPort[8770])
+	read := sink.count("HttpServerAt_8770")
+	await(t, "the reattached log stream", func() bool {
+		return sink.count("HttpServerAt_8770") >= read+3
+	})
+	for n := 0; n < read+3; n++ {
+		if count := sink.count(fmt.Sprintf("Log line %d of HttpServerAt_8770", n)); count != 1 {
+			t.Errorf("Log line %d of HttpServerAt_8770 logged %d times, want once", n, count)
+		}
+	}
+
+	// An exited container leaves the others monitored.
+	if err := fake.ContainerStop(ctx, byPort[8771], nil); err != nil {
+		t.Fatalf("ContainerStop() error = %v", err)
+	}
+	lines := sink.count("HttpServerAt_8770")
+	await(t, "log lines after a container exit", func() bool {
+		return sink.count("HttpServerAt_8770") > lines+3
+	})
+	if !running() {
+		t.Errorf("a container exit ended the monitoring")
+	}
+
+	cancel()
+	stop()
+}
+
+func Test_Supervised_Stats_Streams_Reattach_Fake_Engine(t *testing.T) {
+
+	fake, cfg, owned, byPort := superviseFleet(t)
+
+	statsLogger := logger.NewLogger(logger.Options{}, &recordingSink{})
+	defer statsLogger.Close()
+	fleet := newFleetMetrics(2)
+	liveRecords := func() map[string]StatsRecord {
+		fleet.mutex.Lock()
+		defer fleet.mutex.Unlock()
+		records := make(map[string]StatsRecord, len(fleet.records))
+		for containerID, record := range fleet.records {
+			records[containerID] = record
+		}
+		return records
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var g run.Group
+	if err := monitorContainerStatStreams(ctx, statsLogger, fake, cfg, &g, owned, fleet, nil); err != nil {
+		t.Fatalf("monitorContainerStatStreams() error = %v", err)
+	}
+	running, stop := runGroup(t, &g)
+
+	await(t, "the first stats", func() bool { return len(liveRecords()) == 2 })
+
+	fake.DropStreams(byPort[8770])
+	dropped := liveRecords()[byPort[8770]].Timestamp
+	await(t, "the reattached stats stream", func() bool {
+		return liveRecords()[byPort[8770]].Timestamp.After(dropped.Add(5 * time.Duration(cfg.StreamReattachBackoff)))
+	})
+
+	if err := fake.ContainerStop(ctx, byPort[8771], nil); err != nil {
+		t.Fatalf("ContainerStop() error = %v", err)
+	}
+	await(t, "the exited container to leave the fleet metrics", func() bool {
+		_, live := liveRecords()[byPort[8771]]
+		return !live
+	})
+	if !running() {
+		t.Errorf("a container exit ended the monitoring")
+	}
+
+	cancel()
+	stop()
+}
@@ -2,24 +2,24 @@
 package workflow
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"tlex/config"
 	"tlex/dockerapi"
 	"tlex/logger"
-	"tlex/mapsi2disk"
+	"tlex/state"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/oklog/run"
 	"golang.org/x/sync/errgroup"
 )
@@ -40,52 +40,302 @@ var containersLaunched = make(ContainersLaunched, 1)
 var containersRemoved = make(ContainersRemoved, 1)
 var containersChecked = make(ContainersChecked, 1)
 
-// containers go routine launcher
-var launcherGroup errgroup.Group
-
 // live containers goroutines manager
 var g run.Group
 
+// newEngine returns the container engine facade. Tests replace it with a fakeengine.Engine.
+var newEngine = func(ctx context.Context) (dockerapi.ContainerEngine, error) {
+
+	return dockerapi.GetDockerClient(ctx)
+}
+
 // Workflow performs the necessary steps to accomplish this tool's purpose.
-func Workflow(cfg config.AppConfig) {
+// Errors abort the remaining steps. Once containers are launched, they are removed before returning.
+// Cancelling ctx aborts building and launching or, once the containers are live, starts the teardown.
+func Workflow(ctx context.Context, cfg config.AppConfig) error {
 
 	// Step 0: Facade to the docker remote API
 	dumpConfig(cfg)
-	var dockerClient = dockerapi.GetDockerClient()
+	dockerClient, err := newEngine(ctx)
+	if err != nil {
+		return err
+	}
 	defer dockerClient.Close()
 
 	// Step 1: Build the Docker Image.
-	dockerapi.BuildDockerImage(dockerClient, cfg.DockerFilename)
+	if err = dockerapi.BuildDockerImage(ctx, dockerClient, cfg.DockerFilename, cfg.DockerImageName, time.Duration(cfg.BuildTimeout)); err != nil {
+		return err
+	}
 
 	// Step 2: Create the live Docker Containers.
-	ownedContainers := make(dockerapi.OwnedContainers)
-	ownedContainers.CreateContainers(&launcherGroup, cfg.RequestedLiveContainers, dockerClient, cfg.DockerImageName, cfg.StartingHTTPServerNattedPort, cfg.DockerExposedPort)
-	if err := launcherGroup.Wait(); err != nil {
-		log.Printf("Error while launching containers: %v\n", err)
-		ownedContainers.CleanLeftOverContainers(dockerClient)
-	} else {
-		ownedContainers.PersistOpenContainerIDs()
+	// Step 3: Assume all containers are live.
+	owner := fleetOwner(cfg, dockerapi.NewRunID())
+	ownedContainers, err := launchContainers(ctx, cfg, dockerClient, owner)
+	if err != nil {
+		return fmt.Errorf("launching containers: %w", err)
+	}
+	defer removeContainers(cfg, owner, dockerClient)
+
+	// Step 3.1: Wait for the containers' echo endpoint to pass its health checks, probed until the teardown.
+	probeCtx, stopProbes := context.WithCancel(ctx)
+	defer stopProbes()
+	if err = startHealthChecks(probeCtx, cfg, ownedContainers); err != nil {
+		return fmt.Errorf("launching containers: %w", err)
 	}
 
-	// Step 3: Assume all containers are live.
-	ownedContainers.AssertOwnedContainersAreLive(cfg.RequestedLiveContainers, dockerClient)
 	if cfg.InTestingModeWithChannelsSync {
 		containersLaunched <- true
 	}
 
-	// Step 4: Monitor stats.
-	monitorContainerStatStreams(logger.GetLogger(cfg.StatsFilename), dockerClient, cfg, &g, ownedContainers)
+	// Step 4: Monitor stats, optionally exported at the /metrics endpoint,
+	// while logging the containers events and relaunching the dead containers unless self-healing is disabled.
+	healer := newFleetHealer(cfg, dockerClient, owner.RunID, ownedContainers)
+	healer.reconcile(ctx, &g)
+	if len(ownedContainers) > 0 {
+		eventsLogger, err := openEventsLogger(cfg)
+		if err != nil {
+			return err
+		}
+		defer closeLogger(eventsLogger)
+		watchContainerEvents(ctx, eventsLogger, dockerClient, cfg, &g, owner, healer)
+	}
+	fleet := metricsFor(cfg, len(ownedContainers))
+	statsLogger, err := openStatsLogger(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeLogger(statsLogger)
+	if err = monitorContainerStatStreams(ctx, statsLogger, dockerClient, cfg, &g, ownedContainers, fleet, healer); err != nil {
+		return err
+	}
+	if fleet != nil {
+		if _, err = serveMetrics(cfg.MetricsAddress, &g, fleet); err != nil {
+			return err
+		}
+	}
 
 	// Step 5: Aggregate the containers logs.
-	aggContainersLogStreams(logger.GetLogger(cfg.LogFilename), dockerClient, cfg, &g, ownedContainers)
+	containersLogger, err := openContainersLogger(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeLogger(containersLogger)
+	if err = aggContainersLogStreams(ctx, containersLogger, dockerClient, cfg, &g, ownedContainers, healer); err != nil {
+		return err
+	}
 
 	// Step 6: Hook a clean exit sequence to the interrupt signal.
-	setupTerminateSignal(&g, cfg)
+	awaitTerminateSignal(ctx, &g, cfg.RequestedLiveContainers)
 
 	// Exit concurrent flow when 4, 5, 6 exit or err out.
-	g.Run()
+	err = g.Run()
+	g = run.Group{}
+
+	return err
+}
+
+// fleetOwner selects the containers of the runID launch within the configured host ports,
+// at any host port when Docker chooses them.
+func fleetOwner(cfg config.AppConfig, runID string) dockerapi.Owner {
+
+	if cfg.EphemeralHostPorts {
+		return dockerapi.Owner{RunID: runID}
+	}
 
-	defer removeContainers(cfg, ownedContainers, dockerClient)
+	lastHostPort := cfg.LastHTTPServerNattedPort
+	if lastHostPort == 0 {
+		lastHostPort = 65535
+	}
+
+	return dockerapi.Owner{
+		RunID:         runID,
+		FirstHostPort: cfg.StartingHTTPServerNattedPort,
+		LastHostPort:  lastHostPort,
+	}
+}
+
+// removePreviousLaunch stops the live containers of the launches recorded in the state file, removes their
+// containers left created or exited with removeStale, and deletes the state file once done.
+// The containers of other launches e.g. of other tlex instances sharing the daemon are left alone.
+// There is nothing to remove without a state file.
+func removePreviousLaunch(ctx context.Context, cfg config.AppConfig, dockerClient dockerapi.ContainerEngine, removeStale bool) error {
+
+	launchState, err := state.Load(state.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, runID := range launchState.RunIDs() {
+		owner := fleetOwner(cfg, runID)
+		if err = dockerapi.RemoveLiveContainersFromPreviousRun(ctx, dockerClient, owner, time.Duration(cfg.StopTimeout)); err != nil {
+			return err
+		}
+		if !removeStale {
+			continue
+		}
+		if err = owner.RemoveStaleContainers(ctx, dockerClient, time.Duration(cfg.StopTimeout)); err != nil {
+			return err
+		}
+	}
+	// Containers migrated from a legacy ids.gob carry no run id.
+	legacy := make(dockerapi.OwnedContainers)
+	for _, cont := range launchState.Containers {
+		if cont.RunID == "" {
+			legacy[cont.ID] = cont.HostPort
+		}
+	}
+	if err = legacy.StopOwnedContainers(ctx, dockerClient, time.Duration(cfg.StopTimeout)); err != nil {
+		return err
+	}
+
+	return state.Delete(state.Filename)
+}
+
+// hostPortAllocator hands out the configured host ports of the containers.
+func hostPortAllocator(cfg config.AppConfig) (*dockerapi.PortAllocator, error) {
+
+	if cfg.EphemeralHostPorts {
+		return dockerapi.NewEphemeralPortAllocator(), nil
+	}
+
+	denied, err := config.ParsePortRanges(cfg.DeniedHostPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	return dockerapi.NewPortAllocator(cfg.StartingHTTPServerNattedPort, cfg.LastHTTPServerNattedPort, denied), nil
+}
+
+// containerLimits returns the configured resource limits and restart policy of the containers.
+func containerLimits(cfg config.AppConfig) (*dockerapi.ContainerLimits, error) {
+
+	resources := cfg.ContainerResources
+	ulimits, err := config.ParseUlimits(resources.Ulimits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerapi.ContainerLimits{
+		NanoCPUs:          int64(resources.CPUs * 1e9),
+		Memory:            int64(resources.Memory),
+		MemorySwap:        int64(resources.MemorySwap),
+		PidsLimit:         resources.PidsLimit,
+		Ulimits:           ulimits,
+		RestartPolicy:     resources.RestartPolicy,
+		RestartMaxRetries: resources.RestartMaxRetries,
+	}, nil
+}
+
+// launchContainers creates and starts the requested live containers labeled by owner at the allocated host ports,
+// persists their IDs and host ports and asserts they are live.
+// Containers failing to launch are left out with the continue LaunchFailurePolicy as long as one is launched.
+// With the abort LaunchFailurePolicy the first container failing to launch cancels the other launches.
+// Upon error it stops the launched containers unless the daemon is unreachable.
+// The clean up outlives a cancelled ctx.
+func launchContainers(ctx context.Context, cfg config.AppConfig, dockerClient dockerapi.ContainerEngine, owner dockerapi.Owner) (dockerapi.OwnedContainers, error) {
+
+	hostPorts, err := hostPortAllocator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := containerLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// containers go routine launcher
+	launcherGroup, launchCtx := &errgroup.Group{}, ctx
+	if cfg.LaunchFailurePolicy == config.LaunchFailureAbort {
+		launcherGroup, launchCtx = errgroup.WithContext(ctx)
+	}
+
+	ownedContainers := make(dockerapi.OwnedContainers)
+	launcher := newLauncher(cfg)
+	ownedContainers.CreateContainers(launchCtx, launcherGroup, launcher, cfg.RequestedLiveContainers, dockerClient, owner.RunID, cfg.DockerImageName, limits, hostPorts, cfg.DockerExposedPort, time.Duration(cfg.LaunchTimeout))
+	stopProgress := reportLaunchProgress(launcher, time.Duration(cfg.LaunchProgressInterval))
+	err = launcherGroup.Wait()
+	stopProgress()
+	progress := launcher.Progress()
+	log.Printf("Launched %d containers, %d failed, %d retries in %v. Launch latency: %v.\n", progress.Launched, progress.Failed, progress.Retried, progress.Elapsed.Round(time.Millisecond), launcher.Latencies())
+	if err != nil && cfg.LaunchFailurePolicy == config.LaunchFailureContinue && len(ownedContainers) > 0 && !errors.Is(err, dockerapi.ErrDaemonUnreachable) {
+		log.Printf("Continuing with %d of %d containers: %v\n", len(ownedContainers), cfg.RequestedLiveContainers, err)
+		err = nil
+	}
+	if err == nil {
+		if persistErr := ownedContainers.PersistOwnedContainers(ctx, dockerClient, owner.RunID, nil); persistErr != nil {
+			log.Printf("%v\n", persistErr)
+		}
+		if err = ownedContainers.AssertOwnedContainersAreLive(ctx, len(ownedContainers), dockerClient); err == nil {
+			return ownedContainers, nil
+		}
+		defer state.Delete(state.Filename)
+	}
+
+	switch {
+	case errors.Is(err, dockerapi.ErrDaemonUnreachable):
+		return nil, err
+	case errors.Is(err, dockerapi.ErrPortInUse):
+		log.Printf("Not enough free host ports from %d. Change StartingHTTPServerNattedPort, LastHTTPServerNattedPort or DeniedHostPorts.\n", cfg.StartingHTTPServerNattedPort)
+	case errors.Is(err, dockerapi.ErrImageNotFound):
+		log.Printf("Image %s is missing. Run tlex build first.\n", cfg.DockerImageName)
+	}
+	if cleanErr := owner.CleanLeftOverContainers(context.Background(), dockerClient, time.Duration(cfg.StopTimeout)); cleanErr != nil {
+		log.Printf("Error while cleaning up the launched containers: %v\n", cleanErr)
+	}
+
+	return nil, err
+}
+
+// newLauncher bounds and retries the container launches per cfg.
+// The LaunchFailurePolicy decides when a container launch gives up: abort at the first failure other than
+// a transient one, continue after LaunchAttempts failures of any kind and retry never, until cancelled.
+func newLauncher(cfg config.AppConfig) *dockerapi.Launcher {
+
+	launcher := dockerapi.NewLauncher(cfg.LaunchConcurrency, cfg.LaunchRate)
+	launcher.Attempts = cfg.LaunchAttempts
+	switch cfg.LaunchFailurePolicy {
+	case config.LaunchFailureContinue:
+		launcher.RetryAll = true
+	case config.LaunchFailureRetry:
+		launcher.Attempts = 0
+		launcher.RetryAll = true
+	}
+	launcher.Backoff = time.Duration(cfg.LaunchRetryBackoff)
+	launcher.MaxBackoff = time.Duration(cfg.LaunchRetryMaxBackoff)
+
+	return launcher
+}
+
+// reportLaunchProgress logs the progress of launcher every interval until stopped, unless interval is 0.
+func reportLaunchProgress(launcher *dockerapi.Launcher, interval time.Duration) (stop func()) {
+
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Printf("Launching containers: %v\n", launcher.Progress())
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
 }
 
 func dumpConfig(cfg config.AppConfig) {
@@ -110,12 +360,40 @@ func SetWorkflowSyncWithAPI() (ContainersLaunched, ContainersRemoved, Containers
 	return containersLaunched, containersRemoved, containersChecked
 }
 
-// setupTerminateSignal connects the os.Interrupt signal to a quit channel to
-// start teardown for this process.
-func setupTerminateSignal(g *run.Group, cfg config.AppConfig) {
+// SetupTerminateSignal returns a copy of parent that is cancelled upon the os.Interrupt
+// signal to start teardown for this process. Calling cancel releases the signal handler.
+func SetupTerminateSignal(parent context.Context) (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(parent)
+
+	quit := make(chan os.Signal, 1)
+	// Honor interrupt / kill signals
+	signal.Notify(quit, os.Interrupt, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		select {
+		case <-quit:
+			log.Println()
+			log.Println()
+			log.Println("Received Interrupt signal. Cleaning up and exiting")
+			log.Println("--------------------------------------------------")
+			log.Println()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(quit)
+		cancel()
+	}
+}
+
+// awaitTerminateSignal adds an actor to g waiting for ctx to be cancelled by the
+// terminate signal to start teardown for this process.
+func awaitTerminateSignal(ctx context.Context, g *run.Group, liveContainers int) {
 
 	// No point to wait for 0 containers
-	if cfg.RequestedLiveContainers == 0 {
+	if liveContainers == 0 {
 		return
 	}
 
@@ -125,16 +403,12 @@ func setupTerminateSignal(g *run.Group, cfg config.AppConfig) {
 	log.Println("----------------------------------")
 	log.Println()
 
-	quit := make(chan os.Signal, 1)
-	// Honor interrupt / kill signals
-	signal.Notify(quit, os.Interrupt, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGQUIT)
+	quit := make(chan struct{})
 	g.Add(func() error {
-		<-quit
-		log.Println()
-		log.Println()
-		log.Println("Received Interrupt signal. Cleaning up and exiting")
-		log.Println("--------------------------------------------------")
-		log.Println()
+		select {
+		case <-ctx.Done():
+		case <-quit:
+		}
 
 		return nil
 
@@ -143,114 +417,171 @@ func setupTerminateSignal(g *run.Group, cfg config.AppConfig) {
 	})
 }
 
-// aggContainersLogStreams aggregates the LOGS streams to the single log file, stdout
-func aggContainersLogStreams(containersLogger logger.Logger, dockerClient *client.Client, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers) {
-
-	containersLogReaders := ownedContainers.GetContainersLogReaders(dockerClient)
-	if len(containersLogReaders) > 0 {
+// aggContainersLogStreams aggregates the LOGS streams to the containersLogger sinks
+// as a record per line in cfg.LogFormat, unless filtered out by the cfg log filters.
+// Each container's stream is supervised: reopened from its last line after a failure until the container exits,
+// then followed to the container relaunched by the healer, if any, in its place.
+func aggContainersLogStreams(ctx context.Context, containersLogger logger.Logger, dockerClient dockerapi.ContainerEngine, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers, healer *fleetHealer) error {
 
-		for _, containersLogReader := range containersLogReaders {
-
-			logReader := containersLogReader.ReaderStream
-			hostPort := containersLogReader.HostPort
+	filters, err := newLogFilters(cfg)
+	if err != nil {
+		return err
+	}
 
-			g.Add(func() error {
-				scanner := bufio.NewScanner(logReader)
+	supervisors := []func(context.Context){}
+	for containerID, hostPort := range ownedContainers {
 
-				for scanner.Scan() {
+		// Reopened streams start at the daemon timestamp of the last line read. The sinceLines lines read at
+		// that timestamp e.g. the chunks of a long line are skipped.
+		var since time.Time
+		var sinceLines int
+		supervisor := &streamSupervisor{cfg: cfg, dockerClient: dockerClient, kind: "log", containerID: containerID, hostPort: hostPort, healer: healer}
+		supervisor.open = func(ctx context.Context, containerID string) (dockerapi.ContainerReaderStream, error) {
+			return dockerapi.OpenLogStream(ctx, dockerClient, containerID, supervisor.hostPort, since)
+		}
+		supervisor.replaced = func() {
+			since = time.Time{}
+			sinceLines = 0
+		}
+		supervisor.consume = func(logs dockerapi.ContainerReaderStream) error {
+
+			lineReader := dockerapi.NewLogLineReader(logs.ReaderStream, logs.TTY)
+			skip := sinceLines
+			for {
+				line, err := lineReader.ReadLine()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
 
-					// Strip docker 8 header bytes
-					// https://github.com/moby/moby/issues/7375
-					text := fmt.Sprintf("@ port %d: %s", hostPort, scanner.Text()[8:])
+				record := newLogRecord(logs, line, time.Now())
+				// Lines without a daemon timestamp leave since as is.
+				if _, _, stamped := dockerapi.SplitLogTimestamp(line.Text); stamped {
+					switch {
+					case record.Timestamp.Before(since):
+						continue
+					case record.Timestamp.Equal(since) && skip > 0:
+						skip--
+						continue
+					case record.Timestamp.Equal(since):
+						sinceLines++
+					default:
+						since = record.Timestamp
+						sinceLines = 1
+						skip = 0
+					}
+				}
+				if !filters.allows(record.HostPort, record.Message) {
+					continue
+				}
 
-					log.Println(text)
-					containersLogger.Println(text)
+				level := logger.LevelInfo
+				if cfg.LogLevelDetection {
+					level, _ = detectLevel(record.Message)
+					record.Level = level.String()
 				}
 
-				return nil
+				containersLogger.Log(level, formatLogRecord(cfg.LogFormat, record))
+			}
+		}
+		supervisors = append(supervisors, supervisor.superviseStream)
+	}
+	superviseStreams(ctx, g, supervisors)
 
-			}, func(error) {
+	return nil
+}
 
-				// defer close equivalent
-				logReader.Close()
+// metricsFor returns the fleet metrics of the live containers or nil when the /metrics endpoint is disabled.
+// No point to serve metrics for 0 containers.
+func metricsFor(cfg config.AppConfig, liveContainers int) *fleetMetrics {
 
-			})
-		}
+	if cfg.MetricsAddress == "" || liveContainers == 0 {
+		return nil
 	}
+
+	return newFleetMetrics(cfg.RequestedLiveContainers)
 }
 
 // monitorContainerStatStreams aggregates the STATS streams to single log file (optional), stdout
-func monitorContainerStatStreams(containersStatsLogger logger.Logger, dockerClient *client.Client, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers) {
-
-	const Bytes2MiB float64 = 1024 * 1024
-	const Bytes2GiB float64 = Bytes2MiB * 1024
-
-	containersStatReaders := ownedContainers.GetContainersStatsReaders(dockerClient)
-	if len(containersStatReaders) > 0 {
-
-		for _, containersStatReader := range containersStatReaders {
-
-			statsReader := containersStatReader.ReaderStream
-			hostPort := containersStatReader.HostPort
-
-			g.Add(func() error {
-
-				decoder := json.NewDecoder(statsReader)
-				var stats types.Stats
-
-				resourceSnapshotCnt := 0
-				for err := decoder.Decode(&stats); err != io.EOF && err == nil; err = decoder.Decode(&stats) {
-
-					if cfg.StatsDisplay && resourceSnapshotCnt%cfg.ThrottleStatsInputRequests == 0 {
-						statsBuilder := strings.Builder{}
-						statsBuilder.WriteRune('\n')
-						statsBuilder.WriteString(fmt.Sprintf("Resource Snaphot %d for http server @ port %d, PIDs:%d\n", resourceSnapshotCnt, hostPort, stats.PidsStats.Current))
-						var cpuPercent float64
-						if stats.CPUStats.SystemUsage != 0 {
-							cpuPercent = (float64(stats.CPUStats.CPUUsage.TotalUsage) / float64(stats.CPUStats.SystemUsage)) * float64(len(stats.CPUStats.CPUUsage.PercpuUsage)) * 100.0
-						}
-						statsBuilder.WriteString(fmt.Sprintf("CPU -> CPU %.2f%%, CPUs: %v, Usage Total: %v, System: %v\n", cpuPercent, stats.CPUStats.OnlineCPUs, stats.CPUStats.CPUUsage.TotalUsage, stats.CPUStats.SystemUsage))
-						var memPercent float64
-						if stats.MemoryStats.Limit != 0 {
-							memPercent = float64(stats.MemoryStats.Usage) / float64(stats.MemoryStats.Limit) * 100.0
-						}
-						statsBuilder.WriteString(fmt.Sprintf("Memory -> %.2f%% Usage: %.2fMiB, MaxUsage: %.2fMiB, Limit: %.2fGiB\n", memPercent, float64(stats.MemoryStats.Usage)/Bytes2MiB, float64(stats.MemoryStats.MaxUsage)/Bytes2MiB, float64(stats.MemoryStats.Limit)/Bytes2GiB))
-						statsBuilder.WriteString(fmt.Sprintf("IO -> StorageStats.ReadSizeBytes: %v, Time: %v, Wait Time: %v, Serviced: %v, Service Bytes: %v, Queued: %v\n", stats.StorageStats.ReadSizeBytes, stats.BlkioStats.IoTimeRecursive, stats.BlkioStats.IoWaitTimeRecursive, stats.BlkioStats.IoServicedRecursive, stats.BlkioStats.IoServiceBytesRecursive, stats.BlkioStats.IoQueuedRecursive))
-
-						statsString := statsBuilder.String()
-						log.Println(statsString)
-						if cfg.StatsPersist {
-							containersStatsLogger.Println(statsString)
-						} else {
-							log.Printf("\nStats in non-persistence mode.\n")
-						}
-					}
-
-					resourceSnapshotCnt++
+// and the fleet metrics unless nil.
+// Each container's stream is supervised: reopened after a failure until the container exits,
+// then followed to the container relaunched by the healer, if any, in its place.
+func monitorContainerStatStreams(ctx context.Context, containersStatsLogger logger.Logger, dockerClient dockerapi.ContainerEngine, cfg config.AppConfig, g *run.Group, ownedContainers dockerapi.OwnedContainers, fleet *fleetMetrics, healer *fleetHealer) error {
+
+	supervisors := []func(context.Context){}
+	for containerID, hostPort := range ownedContainers {
+
+		// Every sample feeds the CPU delta of the next one, across reopened streams too.
+		var calc statsCalculator
+		resourceSnapshotCnt := 0
+		supervisor := &streamSupervisor{cfg: cfg, dockerClient: dockerClient, kind: "stats", containerID: containerID, hostPort: hostPort, healer: healer}
+		supervisor.open = func(ctx context.Context, containerID string) (dockerapi.ContainerReaderStream, error) {
+			return dockerapi.OpenStatsStream(ctx, dockerClient, containerID, supervisor.hostPort)
+		}
+		supervisor.replaced = func() {
+			calc = statsCalculator{}
+			if fleet != nil {
+				fleet.forget(supervisor.containerID)
+			}
+		}
+		supervisor.consume = func(statsStream dockerapi.ContainerReaderStream) error {
+
+			decoder := json.NewDecoder(statsStream.ReaderStream)
+			for {
+				var stats types.StatsJSON
+				err := decoder.Decode(&stats)
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
 				}
 
-				return nil
-
-			}, func(error) {
-
-				// defer close equivalent
-				statsReader.Close()
+				record := calc.record(supervisor.hostPort, supervisor.containerID, &stats)
+				record.Restarts = healer.restartsAt(supervisor.hostPort)
+				record.CPULimit, record.PIDsLimit = cfg.ContainerResources.CPUs, uint64(cfg.ContainerResources.PidsLimit)
+				if fleet != nil {
+					fleet.observe(record)
+				}
+				if cfg.StatsDisplay && resourceSnapshotCnt%cfg.ThrottleStatsInputRequests == 0 {
+					statsString := formatStats(cfg.StatsFormat, resourceSnapshotCnt, record, &stats)
+					log.Println(statsString)
+					if cfg.StatsPersist {
+						containersStatsLogger.Println(statsString)
+					} else {
+						log.Printf("\nStats in non-persistence mode.\n")
+					}
+				}
 
-			})
+				resourceSnapshotCnt++
+			}
 		}
+		supervisors = append(supervisors, func(ctx context.Context) {
+			supervisor.superviseStream(ctx)
+			if fleet != nil {
+				fleet.forget(supervisor.containerID)
+			}
+		})
 	}
+	superviseStreams(ctx, g, supervisors)
+
+	return nil
 }
 
-// removeContainers removes the containers from the domain engine. Intended as a late clean up
-// step in the workflow before shutting down.
-func removeContainers(cfg config.AppConfig, ownedContainers dockerapi.OwnedContainers, dockerClient *client.Client) {
+// removeContainers removes the owner's containers from the domain engine. Intended as a late clean up
+// step in the workflow before shutting down, so it does not honor the workflow cancellation.
+func removeContainers(cfg config.AppConfig, owner dockerapi.Owner, dockerClient dockerapi.ContainerEngine) {
 
 	// containers go routine launcher
 	var terminatorGroup sync.WaitGroup
-	ownedContainers.StopAllLiveContainers(&terminatorGroup, dockerClient)
+	if err := owner.StopAllLiveContainers(context.Background(), &terminatorGroup, dockerClient, time.Duration(cfg.StopTimeout)); err != nil {
+		log.Printf("Error while removing containers: %v\n", err)
+	}
 	terminatorGroup.Wait()
 
-	defer mapsi2disk.DeleteFile(mapsi2disk.GobFilename)
+	defer state.Delete(state.Filename)
 
 	//*** Note if InTestingModeWithChannelsSync is set to true during
 	// normal operation it will wait on containersChecked after erasing the containers.
@@ -2,9 +2,9 @@
 package workflow
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"testing"
@@ -12,6 +12,12 @@ import (
 	"tlex/config"
 	"tlex/dockerapi"
 	"tlex/helper"
+	"tlex/internal/fakeengine"
+	"tlex/state"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
 )
 
 func intro(cfg *config.AppConfig, requestedLiveContainers int) {
@@ -20,36 +26,62 @@ func intro(cfg *config.AppConfig, requestedLiveContainers int) {
 	fmt.Printf("Requested %d containers.\n", cfg.RequestedLiveContainers)
 	cfg.LogFilename = helper.GetCWD() + string(os.PathSeparator) + "testdata" + string(os.PathSeparator) + "containers.log"
 	cfg.StatsFilename = helper.GetCWD() + string(os.PathSeparator) + "testdata" + string(os.PathSeparator) + "containers_stats.log"
+	cfg.EventsFilename = helper.GetCWD() + string(os.PathSeparator) + "testdata" + string(os.PathSeparator) + "containers_events.log"
 	cfg.DockerFilename = helper.GetCWD() + string(os.PathSeparator) + "testdata" + string(os.PathSeparator) + "Dockerfile"
 	cfg.InTestingModeWithChannelsSync = true
 }
 
-func testWorkflowXInstancesAppConfig(cfg *config.AppConfig) {
+func testWorkflowXInstancesAppConfig(t *testing.T, cfg *config.AppConfig) {
 
 	intro(cfg, cfg.RequestedLiveContainers)
 
 	containersLaunched, containersRemoved, containersChecked := SetWorkflowSyncWithAPI()
 
 	requestedLiveContainers := cfg.RequestedLiveContainers
+	engine, err := newEngine(context.Background())
+	if err != nil {
+		t.Fatalf("newEngine() error = %v", err)
+	}
 
 	go func() {
 
 		<-containersLaunched
-		dockerapi.AssertRequestedContainersAreLive(requestedLiveContainers)
+		if err := dockerapi.AssertRequestedContainersAreLive(context.Background(), requestedLiveContainers, engine); err != nil {
+			t.Errorf("AssertRequestedContainersAreLive() error = %v", err)
+		}
 		containersChecked <- true
 		<-containersRemoved
-		dockerapi.AssertRequestedContainersAreGone()
+		if err := dockerapi.AssertRequestedContainersAreGone(context.Background(), engine); err != nil {
+			t.Errorf("AssertRequestedContainersAreGone() error = %v", err)
+		}
 		containersChecked <- true
 	}()
 
-	Workflow(*cfg)
+	if err = Workflow(context.Background(), *cfg); err != nil {
+		t.Errorf("Workflow() error = %v", err)
+	}
+}
+
+// useFakeEngine runs the workflow against an in-memory fakeengine.Engine until restore is called.
+func useFakeEngine() (fake *fakeengine.Engine, restore func()) {
+
+	fake = fakeengine.New()
+	fake.Echo = true
+	dockerEngine := newEngine
+	newEngine = func(ctx context.Context) (dockerapi.ContainerEngine, error) {
+		return fake, nil
+	}
+
+	return fake, func() {
+		newEngine = dockerEngine
+	}
 }
 
-func testWorkflowXInstances(requestedLiveContainers int) {
+func testWorkflowXInstances(t *testing.T, requestedLiveContainers int) {
 
 	cfg := config.GetConfig()
 	cfg.RequestedLiveContainers = requestedLiveContainers
-	testWorkflowXInstancesAppConfig(&cfg)
+	testWorkflowXInstancesAppConfig(t, &cfg)
 }
 
 // go test -run Test_Continuous_Logs_Http_Requests_100_Containers -timeout 100000s
@@ -83,28 +115,23 @@ func Test_Continuous_Logs_Http_Requests_100_Containers(t *testing.T) {
 		}
 	}()
 
-	Workflow(cfg)
+	if err := Workflow(context.Background(), cfg); err != nil {
+		t.Errorf("Workflow() error = %v", err)
+	}
 
 }
 
 func fetch(url string) error {
 
 	start := time.Now()
-	resp, err := http.Get(url)
+	echo, err := fetchEcho(context.Background(), http.DefaultClient, url)
 
 	if err != nil {
 		fmt.Printf("http error: %v for %v\n", err, url)
 		return err
 	}
-	nbytes, err := io.Copy(ioutil.Discard, resp.Body)
-	resp.Body.Close() // don't leak resources
-
-	if err != nil {
-		fmt.Print(fmt.Sprintf("while reading %s: %v\n", url, err))
-		return err
-	}
 	secs := time.Since(start).Seconds()
-	fmt.Printf("%.2fs %7d %s\n", secs, nbytes, url)
+	fmt.Printf("%.2fs %7d %s\n", secs, len(echo), url)
 	return err
 }
 
@@ -117,18 +144,28 @@ func Test_Workflow_0_Containers(t *testing.T) {
 	containersLaunched, containersRemoved, containersChecked := SetWorkflowSyncWithAPI()
 
 	requestedLiveContainers := cfg.RequestedLiveContainers
+	engine, err := newEngine(context.Background())
+	if err != nil {
+		t.Fatalf("newEngine() error = %v", err)
+	}
 
 	go func() {
 
 		<-containersLaunched
-		dockerapi.AssertRequestedContainersAreLive(requestedLiveContainers)
+		if err := dockerapi.AssertRequestedContainersAreLive(context.Background(), requestedLiveContainers, engine); err != nil {
+			t.Errorf("AssertRequestedContainersAreLive() error = %v", err)
+		}
 		containersChecked <- true
 		<-containersRemoved
-		dockerapi.AssertRequestedContainersAreGone()
+		if err := dockerapi.AssertRequestedContainersAreGone(context.Background(), engine); err != nil {
+			t.Errorf("AssertRequestedContainersAreGone() error = %v", err)
+		}
 		containersChecked <- true
 	}()
 
-	Workflow(cfg)
+	if err = Workflow(context.Background(), cfg); err != nil {
+		t.Errorf("Workflow() error = %v", err)
+	}
 }
 
 func Test_Workflow_3_Containers_No_Stats(t *testing.T) {
@@ -137,24 +174,311 @@ func Test_Workflow_3_Containers_No_Stats(t *testing.T) {
 	cfg.StatsPersist = false
 	cfg.StatsDisplay = false
 	cfg.RequestedLiveContainers = 3
-	testWorkflowXInstancesAppConfig(&cfg)
+	testWorkflowXInstancesAppConfig(t, &cfg)
 }
 
 func Test_Workflow_1_Containers(t *testing.T) {
 
-	testWorkflowXInstances(1)
+	testWorkflowXInstances(t, 1)
 
 }
 
 func Test_Workflow_5_Containers(t *testing.T) {
 
-	testWorkflowXInstances(5)
+	testWorkflowXInstances(t, 5)
 
 }
 
 // go test -run Test_Workflow_20_Container -timeout 100s
 func Test_Workflow_20_Containers(t *testing.T) {
 
-	testWorkflowXInstances(20)
+	testWorkflowXInstances(t, 20)
+
+}
+
+// go test -run Fake_Engine runs without a Docker daemon.
+func Test_Workflow_0_Containers_Fake_Engine(t *testing.T) {
+
+	_, restore := useFakeEngine()
+	defer restore()
+
+	testWorkflowXInstances(t, 0)
+}
+
+func Test_Workflow_3_Containers_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	cfg := config.GetConfig()
+	cfg.RequestedLiveContainers = 3
+	cfg.ThrottleStatsInputRequests = 1
+	testWorkflowXInstancesAppConfig(t, &cfg)
+
+	if len(fake.BuiltImages) != 1 {
+		t.Errorf("Workflow built %d images, want 1", len(fake.BuiltImages))
+	}
+}
+
+func Test_Build_Tags_The_Configured_Image_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	cfg := config.GetConfig()
+	intro(&cfg, 0)
+	cfg.DockerImageName = "foo:1"
+	if err := Build(context.Background(), cfg); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(fake.BuiltImages) != 1 || fake.BuiltImages[0] != "foo:1" {
+		t.Errorf("Build() tagged %v, want [foo:1]", fake.BuiltImages)
+	}
+}
+
+func Test_Workflow_Port_In_Use_Is_Skipped_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	cfg := config.GetConfig()
+	intro(&cfg, 3)
+	cfg.DeniedHostPorts = []string{fmt.Sprintf("%d", cfg.StartingHTTPServerNattedPort+3)}
+
+	// Occupy the 2nd host port with a foreign container.
+	hostPort := fmt.Sprintf("%d", cfg.StartingHTTPServerNattedPort+1)
+	foreign, err := fake.ContainerCreate(context.Background(), &container.Config{Image: "foreign"},
+		&container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: hostPort}}}}, nil, "foreign")
+	if err == nil {
+		err = fake.ContainerStart(context.Background(), foreign.ID, types.ContainerStartOptions{})
+	}
+	if err != nil {
+		t.Fatalf("Starting the foreign container error = %v", err)
+	}
+	defer fake.ContainerStop(context.Background(), foreign.ID, nil)
+
+	owner := fleetOwner(cfg, "run")
+	ownedContainers, err := launchContainers(context.Background(), cfg, fake, owner)
+	if err != nil {
+		t.Fatalf("launchContainers() error = %v", err)
+	}
+	defer state.Delete(state.Filename)
+	defer owner.CleanLeftOverContainers(context.Background(), fake, 0)
+
+	launchState, err := state.Load(state.Filename)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	wantPorts := []int{cfg.StartingHTTPServerNattedPort, cfg.StartingHTTPServerNattedPort + 2, cfg.StartingHTTPServerNattedPort + 4}
+	if len(launchState.Containers) != len(wantPorts) {
+		t.Fatalf("State of %d containers, want %d", len(launchState.Containers), len(wantPorts))
+	}
+	for i, stateContainer := range launchState.Containers {
+		if stateContainer.HostPort != wantPorts[i] || ownedContainers[stateContainer.ID] != wantPorts[i] {
+			t.Errorf("Container %d at host port %d, owned at %d, want %d", i, stateContainer.HostPort, ownedContainers[stateContainer.ID], wantPorts[i])
+		}
+	}
+}
+
+func Test_Workflow_Ephemeral_Host_Ports_Fake_Engine(t *testing.T) {
 
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	cfg := config.GetConfig()
+	intro(&cfg, 2)
+	cfg.EphemeralHostPorts = true
+
+	owner := fleetOwner(cfg, "run")
+	ownedContainers, err := launchContainers(context.Background(), cfg, fake, owner)
+	if err != nil {
+		t.Fatalf("launchContainers() error = %v", err)
+	}
+	defer state.Delete(state.Filename)
+	defer owner.CleanLeftOverContainers(context.Background(), fake, 0)
+
+	// The fake engine serves the echo endpoint at the host ports chosen for it.
+	for _, hostPort := range ownedContainers {
+		if err := probeEcho(context.Background(), http.DefaultClient, "127.0.0.1", hostPort, cfg.HealthCheckPath); err != nil {
+			t.Errorf("Container at ephemeral host port %d: %v", hostPort, err)
+		}
+	}
+	launchState, err := state.Load(state.Filename)
+	if err != nil {
+		t.Fatalf("state.Load() error = %v", err)
+	}
+	for _, stateContainer := range launchState.Containers {
+		if stateContainer.HostPort == 0 || stateContainer.HostPort != ownedContainers[stateContainer.ID] {
+			t.Errorf("State of container %s at host port %d, owned at %d", stateContainer.Name, stateContainer.HostPort, ownedContainers[stateContainer.ID])
+		}
+	}
+}
+
+func Test_Workflow_Previous_Launch_Spares_Other_Instances_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	// Ephemeral host ports leave the launches no host port bounds telling them apart.
+	cfg := config.GetConfig()
+	intro(&cfg, 2)
+	cfg.EphemeralHostPorts = true
+
+	other := fleetOwner(cfg, "other")
+	if _, err := launchContainers(context.Background(), cfg, fake, other); err != nil {
+		t.Fatalf("launchContainers() of the other instance error = %v", err)
+	}
+	defer other.CleanLeftOverContainers(context.Background(), fake, 0)
+	previous := fleetOwner(cfg, "previous")
+	if _, err := launchContainers(context.Background(), cfg, fake, previous); err != nil {
+		t.Fatalf("launchContainers() error = %v", err)
+	}
+	defer state.Delete(state.Filename)
+
+	if err := removePreviousLaunch(context.Background(), cfg, fake, true); err != nil {
+		t.Fatalf("removePreviousLaunch() error = %v", err)
+	}
+	if containers, err := previous.ListContainers(context.Background(), fake); err != nil || len(containers) != 0 {
+		t.Errorf("removePreviousLaunch() left %d containers of the recorded launch, error = %v", len(containers), err)
+	}
+	if containers, err := other.ListContainers(context.Background(), fake); err != nil || len(containers) != 2 {
+		t.Errorf("removePreviousLaunch() left %d of the other instance's 2 containers, error = %v", len(containers), err)
+	}
+	if _, err := state.Load(state.Filename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removePreviousLaunch() kept the state file, load error = %v", err)
+	}
+}
+
+func Test_Workflow_Down_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+
+	cfg := config.GetConfig()
+	intro(&cfg, 2)
+
+	if err := Down(context.Background(), cfg); err == nil {
+		t.Errorf("Down() without a state file error = nil, want an error")
+	}
+
+	owner := fleetOwner(cfg, dockerapi.NewRunID())
+	if _, err := launchContainers(context.Background(), cfg, fake, owner); err != nil {
+		t.Fatalf("launchContainers() error = %v", err)
+	}
+	defer state.Delete(state.Filename)
+	defer owner.CleanLeftOverContainers(context.Background(), fake, 0)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Down(cancelled, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("Down() cancelled error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := state.Load(state.Filename); err != nil {
+		t.Errorf("Down() failing deleted the state file, load error = %v", err)
+	}
+
+	if err := Down(context.Background(), cfg); err != nil {
+		t.Fatalf("Down() error = %v", err)
+	}
+	if containers, err := owner.ListContainers(context.Background(), fake); err != nil || len(containers) != 0 {
+		t.Errorf("Down() left %d live containers, error = %v", len(containers), err)
+	}
+	if _, err := state.Load(state.Filename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Down() kept the state file, load error = %v", err)
+	}
+}
+
+func Test_Workflow_Launch_Timeout_Fake_Engine(t *testing.T) {
+
+	fake, restore := useFakeEngine()
+	defer restore()
+	fake.Delay = 100 * time.Millisecond
+
+	cfg := config.GetConfig()
+	intro(&cfg, 3)
+	cfg.InTestingModeWithChannelsSync = false
+	cfg.LaunchTimeout = config.Duration(20 * time.Millisecond)
+
+	if err := Workflow(context.Background(), cfg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Workflow() error = %v, want context.DeadlineExceeded", err)
+	}
+	if err := dockerapi.AssertRequestedContainersAreGone(context.Background(), fake); err != nil {
+		t.Errorf("Timed out containers were not cleaned up: %v", err)
+	}
+}
+
+func Test_Workflow_Launch_Failure_Policies_Fake_Engine(t *testing.T) {
+
+	busy := errors.New("Error response from daemon: Too Many Requests")
+	tests := []struct {
+		policy      string
+		attempts    int
+		concurrency int
+		failures    []error
+		wantErr     error
+		wantOwned   int
+	}{
+		{policy: config.LaunchFailureAbort, attempts: 2, failures: []error{errors.New("No such image: echo:latest")}, wantErr: dockerapi.ErrImageNotFound},
+		{policy: config.LaunchFailureContinue, attempts: 1, failures: []error{errors.New("No such image: echo:latest")}, wantOwned: 2},
+		{policy: config.LaunchFailureContinue, attempts: 2, failures: []error{errors.New("No such image: echo:latest")}, wantOwned: 3},
+		{policy: config.LaunchFailureAbort, attempts: 2, failures: []error{busy, busy, busy, busy}, wantErr: dockerapi.ErrDaemonBusy},
+		{policy: config.LaunchFailureAbort, attempts: 2, concurrency: 3, failures: []error{errors.New("No such image: echo:latest")}, wantErr: dockerapi.ErrImageNotFound},
+		{policy: config.LaunchFailureRetry, attempts: 2, failures: []error{busy, busy, busy, busy}, wantOwned: 3},
+		{policy: config.LaunchFailureRetry, attempts: 2, failures: []error{errors.New("No such image: echo:latest"), errors.New("No such image: echo:latest"), errors.New("No such image: echo:latest")}, wantOwned: 3},
+	}
+	for _, tt := range tests {
+		fake, restore := useFakeEngine()
+		fake.CreateFailures = tt.failures
+
+		cfg := config.GetConfig()
+		intro(&cfg, 3)
+		cfg.LaunchFailurePolicy = tt.policy
+		cfg.LaunchAttempts = tt.attempts
+		cfg.LaunchConcurrency = 1
+		if tt.concurrency > 1 {
+			// The sibling launches are still starting when the first one fails.
+			fake.Delay = 50 * time.Millisecond
+			cfg.LaunchConcurrency = tt.concurrency
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		messages, _ := fake.Events(ctx, types.EventsOptions{})
+		cfg.LaunchRetryBackoff = config.Duration(time.Millisecond)
+		cfg.LaunchRetryMaxBackoff = config.Duration(time.Millisecond)
+
+		owner := fleetOwner(cfg, "run")
+		ownedContainers, err := launchContainers(context.Background(), cfg, fake, owner)
+		if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s after %d attempts: launchContainers() error = %v, want %v", tt.policy, tt.attempts, err, tt.wantErr)
+		}
+		started := 0
+		for drained := false; !drained; {
+			select {
+			case message := <-messages:
+				if message.Action == "start" {
+					started++
+				}
+			default:
+				drained = true
+			}
+		}
+		cancel()
+		if tt.concurrency > 1 && tt.wantErr != nil && started > 0 {
+			t.Errorf("%s with %d launches in flight: %d containers started after the first failure", tt.policy, tt.concurrency, started)
+		}
+		if len(ownedContainers) != tt.wantOwned {
+			t.Errorf("%s after %d attempts: launchContainers() owns %d containers, want %d", tt.policy, tt.attempts, len(ownedContainers), tt.wantOwned)
+		}
+		if tt.wantOwned == 0 {
+			err = dockerapi.AssertRequestedContainersAreGone(context.Background(), fake)
+		} else {
+			err = dockerapi.AssertRequestedContainersAreLive(context.Background(), tt.wantOwned, fake)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.policy, err)
+		}
+
+		owner.CleanLeftOverContainers(context.Background(), fake, 0)
+		state.Delete(state.Filename)
+		restore()
+	}
 }